@@ -1,33 +1,75 @@
 package pipeline
 
+import "context"
+
 func flushChan(inCh <-chan interface{}) {
+	if inCh == nil {
+		return
+	}
 	for range inCh {
 	}
 }
 
-func multiplexChan(inCh <-chan interface{}, outChs ...chan interface{}) {
-	for in := range inCh {
-		for _, chOut := range outChs {
-			chOut <- in
-		}
+// flushOnCancel flushes the given channel in background if the context is done, in order to
+// unblock the stages writing on it.
+func flushOnCancel(ctx context.Context, inCh <-chan interface{}) {
+	if ctx.Err() != nil && inCh != nil {
+		go flushChan(inCh)
 	}
+}
 
-	for _, chOut := range outChs {
-		close(chOut)
+// recvContext receives the next value of the given channel. It returns false if the channel is
+// closed or if the context is done.
+func recvContext(ctx context.Context, inCh <-chan interface{}) (interface{}, bool) {
+	if ctx.Err() != nil {
+		return nil, false
+	}
+
+	select {
+	case in, open := <-inCh:
+		return in, open
+	case <-ctx.Done():
+		return nil, false
 	}
 }
 
-func multiplexChanNoLock(inCh <-chan interface{}, outChs ...chan interface{}) {
-	for in := range inCh {
-		for _, chOut := range outChs {
-			select {
-			case chOut <- in:
-			default:
-			}
-		}
+// sendContext sends the given value to the channel. It returns false if the context is done before
+// the value is sent.
+func sendContext(ctx context.Context, outCh chan<- interface{}, out interface{}) bool {
+	select {
+	case outCh <- out:
+		return true
+	case <-ctx.Done():
+		return false
 	}
+}
 
-	for _, chOut := range outChs {
-		close(chOut)
+// bindChan returns a channel forwarding all values of the given channel until it is closed or
+// until the context is done.
+func bindChan(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+	if inCh == nil || ctx.Done() == nil {
+		return inCh
 	}
+
+	outCh := make(chan interface{}, cap(inCh))
+	go func() {
+		defer close(outCh)
+
+		for {
+			in, open := recvContext(ctx, inCh)
+			if !open || !sendContext(ctx, outCh, in) {
+				break
+			}
+		}
+		flushOnCancel(ctx, inCh)
+	}()
+	return outCh
+}
+
+func multiplexChan(inCh <-chan interface{}, outChs ...chan interface{}) {
+	(&stageRuntime{ctx: context.Background()}).multiplex(inCh, outChs...)
+}
+
+func multiplexChanNoLock(inCh <-chan interface{}, outChs ...chan interface{}) {
+	(&stageRuntime{ctx: context.Background()}).multiplexNoLock(inCh, outChs...)
 }
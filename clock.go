@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Clock provides the time to the stages depending on it (see WithClock). It can be replaced in tests
+// in order to control the time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock.
+type Timer interface {
+	C() <-chan time.Time // C receives the time when the timer fires
+	Stop() bool
+}
+
+// SystemClock is the Clock based on the system time, used by default.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                 { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+func clockFrom(ctx context.Context) Clock {
+	if exec := executionFrom(ctx); exec != nil && exec.clock != nil {
+		return exec.clock
+	}
+	return SystemClock
+}
+
+// sleepContext waits for the given duration with the given clock. It returns false if the context is
+// done before.
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,104 @@
+package pipeline_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// fakeClock is a pipeline.Clock whose time only changes when advanced.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	created int
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(0, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) pipeline.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.created++
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+	} else {
+		c.timers = append(c.timers, timer)
+	}
+	return timer
+}
+
+// Advance moves the time forward and fires the expired timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+// WaitTimers waits until n timers are pending.
+func (c *fakeClock) WaitTimers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.lock.Lock()
+		pending := len(c.timers)
+		c.lock.Unlock()
+		if pending == n {
+			return
+		}
+	}
+	t.Fatalf("%d timers expected", n)
+}
+
+// WaitCreated waits until n timers have been created since the clock creation.
+func (c *fakeClock) WaitCreated(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.lock.Lock()
+		created := c.created
+		c.lock.Unlock()
+		if created == n {
+			return
+		}
+	}
+	t.Fatalf("%d timers expected", n)
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// ItemError is an error returned by a stage while processing an item.
+type ItemError struct {
+	Stage string      // Stage is the name of the stage which failed
+	Item  interface{} // Item is the input being processed when the stage failed (nil if unknown)
+	Err   error
+}
+
+func (e *ItemError) Error() string { return fmt.Sprintf("%s: %v", e.Stage, e.Err) }
+
+// Unwrap returns the underlying error.
+func (e *ItemError) Unwrap() error { return e.Err }
+
+// ReportError reports an error to the pipeline running the stage (see Pipeline.Start). It is used by
+// all built-in stages and can be used by custom stages to surface their own errors. When the stage
+// is not run through Pipeline.Start, the error is dropped.
+func ReportError(ctx context.Context, stage string, item interface{}, err error) {
+	if exec := executionFrom(ctx); exec != nil {
+		exec.report(&ItemError{Stage: stage, Item: item, Err: err})
+	}
+}
+
+// PanicError is the error reported when a stage panics. It is always wrapped in an ItemError
+// describing the stage and the item being processed.
+type PanicError struct {
+	Value interface{} // Value is the value given to panic
+	Stack []byte      // Stack is the stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("panic: %v", e.Value) }
+
+// PanicPolicy defines how a panic recovered in a stage is handled.
+type PanicPolicy int
+
+const (
+	// PanicAbort stops the pipeline; the panic is returned by Execution.Wait.
+	PanicAbort PanicPolicy = iota
+	// PanicDrop drops the item being processed.
+	PanicDrop
+	// PanicDeadLetter drops the item being processed and sends it to Execution.Errors.
+	PanicDeadLetter
+	// PanicRestart restarts the stage, without the item being processed.
+	PanicRestart
+)
+
+// Protect calls fnc and recovers from its panic, which is handled depending on the PanicPolicy of
+// the pipeline (see WithPanicPolicy). It is used by all built-in stages and can be used by custom
+// stages. If fnc panicked, Protect returns false with the policy to apply: the caller must skip the
+// item (PanicDrop and PanicDeadLetter), restart (PanicRestart) or stop (PanicAbort).
+// NOTE: When the stage is not run through Pipeline.Start, the panic is not recovered: it crashes the
+// program like a panic of a stage which doesn't use Protect, instead of being lost.
+func Protect(ctx context.Context, stage string, item interface{}, fnc func()) (ok bool, policy PanicPolicy) {
+	err, policy := protect(ctx, stage, item, fnc)
+	return err == nil, policy
+}
+
+// protect is like Protect, but returns the recovered panic (nil if fnc didn't panic).
+func protect(ctx context.Context, stage string, item interface{}, fnc func()) (err *PanicError, policy PanicPolicy) {
+	exec := executionFrom(ctx)
+	if exec == nil {
+		fnc() // nothing would report the panic
+		return nil, policy
+	}
+
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+
+		err, policy = &PanicError{Value: value, Stack: debug.Stack()}, exec.panicPolicy
+		switch policy {
+		case PanicAbort:
+			exec.fail(&ItemError{Stage: stage, Item: item, Err: err})
+		case PanicDeadLetter:
+			exec.sendError(&ItemError{Stage: stage, Item: item, Err: err})
+		}
+	}()
+
+	fnc()
+	return nil, policy
+}
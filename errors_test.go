@@ -0,0 +1,102 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func panicOnOdd(obj interface{}) interface{} {
+	if obj.(int)%2 == 1 {
+		panic("odd number")
+	}
+	return obj
+}
+
+func TestProtect_Abort(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.C(panicOnOdd)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+
+	in <- 3
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	err := exec.Wait()
+	if assert.IsType(t, &pipeline.ItemError{}, err) {
+		assert.Equal(t, "0.consumer", err.(*pipeline.ItemError).Stage)
+		assert.Equal(t, 3, err.(*pipeline.ItemError).Item)
+		if assert.IsType(t, &pipeline.PanicError{}, err.(*pipeline.ItemError).Err) {
+			assert.Equal(t, "odd number", err.(*pipeline.ItemError).Err.(*pipeline.PanicError).Value)
+			assert.NotEmpty(t, err.(*pipeline.ItemError).Err.(*pipeline.PanicError).Stack)
+		}
+	}
+}
+
+func TestProtect_WithoutExecution(t *testing.T) {
+	// without Pipeline.Start, nothing would report the panic, which must not be lost
+	assert.PanicsWithValue(t, "odd number", func() {
+		pipeline.Protect(context.Background(), "consumer", 3, func() { panicOnOdd(3) })
+	})
+}
+
+func TestProtect_Drop(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.C(panicOnOdd)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithPanicPolicy(pipeline.PanicDrop))
+
+	in <- 3
+	in <- 2
+	close(in)
+
+	assert.Equal(t, 2, <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+}
+
+func TestProtect_DeadLetter(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.C(panicOnOdd)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithPanicPolicy(pipeline.PanicDeadLetter))
+
+	in <- 3
+	err := <-exec.Errors()
+	assert.Equal(t, 3, err.Item)
+	assert.IsType(t, &pipeline.PanicError{}, err.Err)
+
+	in <- 2
+	close(in)
+
+	assert.Equal(t, 2, <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+}
+
+func TestProtect_Restart(t *testing.T) {
+	starts := 0
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(1, pipeline.StageFnc(func(in <-chan interface{}) <-chan interface{} {
+			if starts++; starts == 1 {
+				panic("first start")
+			}
+			return in
+		})),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithPanicPolicy(pipeline.PanicRestart))
+
+	in <- 1
+	close(in)
+
+	assert.Equal(t, 1, <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, 2, starts)
+}
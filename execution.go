@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// ErrorMode defines how a pipeline started with Pipeline.Start handles the errors reported by its
+// stages.
+type ErrorMode int
+
+const (
+	// FailFast cancels the whole pipeline on the first error, which is returned by Execution.Wait.
+	FailFast ErrorMode = iota
+	// ContinueOnError sends the failed items to Execution.Errors and keeps the pipeline running.
+	ContinueOnError
+)
+
+// RunOption configures a pipeline started with Pipeline.Start.
+type RunOption func(*Execution)
+
+// WithErrorMode defines how errors reported by the stages are handled (FailFast by default).
+func WithErrorMode(mode ErrorMode) RunOption { return func(e *Execution) { e.mode = mode } }
+
+// WithPanicPolicy defines how panics recovered in the stages are handled (PanicAbort by default).
+func WithPanicPolicy(policy PanicPolicy) RunOption {
+	return func(e *Execution) { e.panicPolicy = policy }
+}
+
+// WithBufferSize defines the capacity of the channels created by the stages of the pipeline
+// (unbuffered if negative), unless a stage defines its own capacity (see BufferSize).
+func WithBufferSize(size int) RunOption {
+	return func(e *Execution) { e.bufferSize, e.hasBufferSize = nonNegative(size), true }
+}
+
+// WithInstrumentation defines the instrumentation receiving the measurements of all built-in stages
+// of the pipeline (see Collector).
+func WithInstrumentation(instr Instrumentation) RunOption {
+	return func(e *Execution) { e.instr = instr }
+}
+
+// WithTracer defines the tracer receiving the spans opened by all built-in stages of the pipeline
+// for each item (see Tracer).
+func WithTracer(tracer Tracer) RunOption { return func(e *Execution) { e.tracer = tracer } }
+
+// WithClock defines the clock used by all stages of the pipeline depending on the time (SystemClock
+// by default).
+func WithClock(clock Clock) RunOption { return func(e *Execution) { e.clock = clock } }
+
+// Execution is a pipeline started with Pipeline.Start.
+type Execution struct {
+	mode          ErrorMode
+	panicPolicy   PanicPolicy
+	bufferSize    int
+	hasBufferSize bool
+	instr         Instrumentation
+	tracer        Tracer
+	clock         Clock
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	out    chan interface{}
+	done   chan struct{}
+
+	errOnce sync.Once
+	err     error
+
+	errsLock   sync.RWMutex
+	errs       chan *ItemError
+	errsClosed bool
+}
+
+type executionKey struct{}
+
+func executionFrom(ctx context.Context) *Execution {
+	exec, _ := ctx.Value(executionKey{}).(*Execution)
+	return exec
+}
+
+// Out returns the output channel of the pipeline. It must be consumed until it is closed.
+func (e *Execution) Out() <-chan interface{} { return e.out }
+
+// Errors returns the channel receiving the failed items when the ContinueOnError mode is used, and
+// the items which made a stage panic when the PanicDeadLetter policy is used. This channel is closed
+// with the output channel and must be consumed concurrently with it, otherwise the failing stages
+// are blocked.
+func (e *Execution) Errors() <-chan *ItemError { return e.errs }
+
+// Cancel stops the pipeline.
+func (e *Execution) Cancel() { e.cancel() }
+
+// Wait waits until the output channel is closed and returns the first error reported in FailFast
+// mode, or the context error if the pipeline was cancelled.
+func (e *Execution) Wait() error {
+	<-e.done
+	return e.err
+}
+
+func (e *Execution) run(values <-chan interface{}) {
+	defer close(e.done)
+
+	for {
+		value, open := recvContext(e.ctx, values)
+		if !open || !sendContext(e.ctx, e.out, value) {
+			break
+		}
+	}
+	flushOnCancel(e.ctx, values)
+	close(e.out)
+
+	e.errOnce.Do(func() { e.err = e.ctx.Err() }) // errors reported from now on are ignored
+	e.cancel()                                   // release the context and unblock late reporters
+
+	e.errsLock.Lock()
+	e.errsClosed = true
+	close(e.errs)
+	e.errsLock.Unlock()
+}
+
+func (e *Execution) fail(err error) {
+	e.errOnce.Do(func() {
+		e.err = err
+		e.cancel()
+	})
+}
+
+func (e *Execution) report(err *ItemError) {
+	if e.mode == FailFast {
+		e.fail(err)
+		return
+	}
+	e.sendError(err)
+}
+
+func (e *Execution) sendError(err *ItemError) {
+	e.errsLock.RLock()
+	defer e.errsLock.RUnlock()
+	if e.errsClosed {
+		return
+	}
+
+	select {
+	case e.errs <- err:
+	case <-e.ctx.Done():
+	}
+}
@@ -0,0 +1,130 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+var errOdd = errors.New("odd number")
+
+func TestPipeline_Start(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 10, <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+}
+
+func TestPipeline_Start_FailFast(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.CE(func(obj interface{}) (interface{}, error) {
+			if obj.(int)%2 == 1 {
+				return nil, errOdd
+			}
+			return obj, nil
+		}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+
+	in <- 2
+	assert.Equal(t, 2, <-exec.Out())
+	in <- 3
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	err := exec.Wait()
+	if assert.IsType(t, &pipeline.ItemError{}, err) {
+		assert.Equal(t, errOdd, err.(*pipeline.ItemError).Err)
+		assert.Equal(t, 3, err.(*pipeline.ItemError).Item)
+	}
+}
+
+func TestPipeline_Start_ContinueOnError(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.CE(func(obj interface{}) (interface{}, error) {
+			if obj.(int)%2 == 1 {
+				return nil, errOdd
+			}
+			return obj, nil
+		}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithErrorMode(pipeline.ContinueOnError))
+
+	in <- 3
+	err := <-exec.Errors()
+	assert.Equal(t, errOdd, err.Err)
+	assert.Equal(t, 3, err.Item)
+
+	in <- 2
+	assert.Equal(t, 2, <-exec.Out())
+	close(in)
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+	_, open := <-exec.Errors()
+	assert.False(t, open)
+}
+
+func TestExecution_Cancel(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+	exec.Cancel()
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, context.Canceled, exec.Wait())
+}
+
+func TestPipeline_Start_BufferSize(t *testing.T) {
+	var capacities []int
+	capture := pipeline.StageFnc(func(in <-chan interface{}) <-chan interface{} {
+		capacities = append(capacities, cap(in))
+		return in
+	})
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+		capture,
+		pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.BufferSize(2)),
+		capture,
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithBufferSize(7))
+	close(in)
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, []int{7, 2}, capacities)
+	assert.Equal(t, 7, cap(exec.Out()))
+}
+
+func TestPipeline_Start_NegativeBufferSize(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj })}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithBufferSize(-1))
+	defer close(in)
+
+	assert.Equal(t, 0, cap(exec.Out()))
+	in <- 1
+	assert.Equal(t, 1, <-exec.Out())
+}
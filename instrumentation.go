@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Instrumentation receives the measurements of all built-in stages of a pipeline (see
+// WithInstrumentation). Each stage is identified by its ID, composed of the IDs of its parent stages,
+// its index in its pipeline and its name (see Name), like "1.lrfilter/left/0.consumer".
+// IMPORTANT: Instrumentation methods are called concurrently by the stages and must not block.
+type Instrumentation interface {
+	// ItemIn is called when the stage receives an item.
+	ItemIn(stage string)
+	// ItemOut is called when the stage sends an item.
+	ItemOut(stage string)
+	// ItemDropped is called when the stage drops an item (because of an error, a panic or a blocked
+	// mirror for example).
+	ItemDropped(stage string)
+	// ItemProcessed is called with the time spent to process an item.
+	ItemProcessed(stage string, latency time.Duration)
+	// QueueDepth is called with the number of items waiting in the output channel of the stage, each
+	// time an item is sent; or in the input channel of a branch (like the stages of a Fork), each time
+	// an item is sent to this branch.
+	QueueDepth(stage string, depth int)
+	// SendBlocked is called with the time spent to send an item to the output channel of the stage,
+	// or to the input channel of one of its branches.
+	SendBlocked(stage string, blocked time.Duration)
+	// WorkerActive is called when a worker of the stage (like a Parallelize worker) starts (delta = 1)
+	// or stops (delta = -1).
+	WorkerActive(stage string, delta int)
+}
+
+// CounterInstrumentation is an Instrumentation also receiving the named counters of the stages, like
+// the routes of Router.
+type CounterInstrumentation interface {
+	Instrumentation
+	// Count is called each time the given counter of the stage is incremented.
+	Count(stage, counter string)
+}
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram buckets used by a Collector
+// when none are given.
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// StageMetrics are the measurements of a stage collected by a Collector.
+type StageMetrics struct {
+	ItemsIn         uint64
+	ItemsOut        uint64
+	ItemsDropped    uint64
+	ItemsProcessed  uint64
+	ProcessingTime  time.Duration // ProcessingTime is the total time spent to process the items
+	QueueDepth      int           // QueueDepth is the last known number of items in the output channel
+	MaxQueueDepth   int
+	SendBlockedTime time.Duration     // SendBlockedTime is the total time spent to send the items
+	ActiveWorkers   int               // ActiveWorkers is the number of running workers (see Parallelize)
+	Latency         Histogram         // Latency is the distribution of the time spent to process the items
+	Counters        map[string]uint64 // Counters are the named counters of the stage (see CounterInstrumentation)
+}
+
+// Histogram is a distribution of durations.
+type Histogram struct {
+	Bounds []time.Duration // Bounds are the sorted upper bounds of the buckets
+	Counts []uint64        // Counts are the number of values of each bucket, the last one being unbounded
+}
+
+// Collector is an in-memory CounterInstrumentation, collecting the measurements of all stages.
+type Collector struct {
+	bounds []time.Duration
+	lock   sync.RWMutex
+	stages map[string]*stageCollector
+}
+
+type stageCollector struct {
+	itemsIn, itemsOut, itemsDropped, itemsProcessed uint64
+	processingTime, sendBlockedTime                 int64
+	queueDepth, maxQueueDepth, activeWorkers        int64
+	latency                                         []uint64
+	counters                                        sync.Map // counters are the named counters (*uint64)
+}
+
+// NewCollector returns an empty Collector, using the given latency histogram bucket bounds (or
+// DefaultLatencyBuckets if none are given).
+func NewCollector(buckets ...time.Duration) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	bounds := append([]time.Duration(nil), buckets...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &Collector{bounds: bounds, stages: map[string]*stageCollector{}}
+}
+
+func (c *Collector) stage(stage string) *stageCollector {
+	c.lock.RLock()
+	collector, exists := c.stages[stage]
+	c.lock.RUnlock()
+	if exists {
+		return collector
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if collector, exists = c.stages[stage]; !exists {
+		collector = &stageCollector{latency: make([]uint64, len(c.bounds)+1)}
+		c.stages[stage] = collector
+	}
+	return collector
+}
+
+func (c *Collector) ItemIn(stage string)      { atomic.AddUint64(&c.stage(stage).itemsIn, 1) }
+func (c *Collector) ItemOut(stage string)     { atomic.AddUint64(&c.stage(stage).itemsOut, 1) }
+func (c *Collector) ItemDropped(stage string) { atomic.AddUint64(&c.stage(stage).itemsDropped, 1) }
+func (c *Collector) ItemProcessed(stage string, latency time.Duration) {
+	collector := c.stage(stage)
+	atomic.AddUint64(&collector.itemsProcessed, 1)
+	atomic.AddInt64(&collector.processingTime, int64(latency))
+	atomic.AddUint64(&collector.latency[sort.Search(len(c.bounds), func(i int) bool { return latency <= c.bounds[i] })], 1)
+}
+func (c *Collector) QueueDepth(stage string, depth int) {
+	collector := c.stage(stage)
+	atomic.StoreInt64(&collector.queueDepth, int64(depth))
+	for max := atomic.LoadInt64(&collector.maxQueueDepth); int64(depth) > max; max = atomic.LoadInt64(&collector.maxQueueDepth) {
+		if atomic.CompareAndSwapInt64(&collector.maxQueueDepth, max, int64(depth)) {
+			break
+		}
+	}
+}
+func (c *Collector) SendBlocked(stage string, blocked time.Duration) {
+	atomic.AddInt64(&c.stage(stage).sendBlockedTime, int64(blocked))
+}
+func (c *Collector) WorkerActive(stage string, delta int) {
+	atomic.AddInt64(&c.stage(stage).activeWorkers, int64(delta))
+}
+func (c *Collector) Count(stage, counter string) {
+	collector := c.stage(stage)
+	value, exists := collector.counters.Load(counter)
+	if !exists {
+		value, _ = collector.counters.LoadOrStore(counter, new(uint64))
+	}
+	atomic.AddUint64(value.(*uint64), 1)
+}
+
+// Stages returns the sorted IDs of all stages measured by the collector.
+func (c *Collector) Stages() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stages := make([]string, 0, len(c.stages))
+	for stage := range c.stages {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	return stages
+}
+
+// Snapshot returns the current measurements of all stages, indexed by stage ID.
+func (c *Collector) Snapshot() map[string]StageMetrics {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	snapshot := make(map[string]StageMetrics, len(c.stages))
+	for stage, collector := range c.stages {
+		latency := Histogram{Bounds: c.bounds, Counts: make([]uint64, len(collector.latency))}
+		for i := range collector.latency {
+			latency.Counts[i] = atomic.LoadUint64(&collector.latency[i])
+		}
+
+		var counters map[string]uint64
+		collector.counters.Range(func(counter, value interface{}) bool {
+			if counters == nil {
+				counters = map[string]uint64{}
+			}
+			counters[counter.(string)] = atomic.LoadUint64(value.(*uint64))
+			return true
+		})
+
+		snapshot[stage] = StageMetrics{
+			ItemsIn:         atomic.LoadUint64(&collector.itemsIn),
+			ItemsOut:        atomic.LoadUint64(&collector.itemsOut),
+			ItemsDropped:    atomic.LoadUint64(&collector.itemsDropped),
+			ItemsProcessed:  atomic.LoadUint64(&collector.itemsProcessed),
+			ProcessingTime:  time.Duration(atomic.LoadInt64(&collector.processingTime)),
+			QueueDepth:      int(atomic.LoadInt64(&collector.queueDepth)),
+			MaxQueueDepth:   int(atomic.LoadInt64(&collector.maxQueueDepth)),
+			SendBlockedTime: time.Duration(atomic.LoadInt64(&collector.sendBlockedTime)),
+			ActiveWorkers:   int(atomic.LoadInt64(&collector.activeWorkers)),
+			Latency:         latency,
+			Counters:        counters,
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricsHandler returns an http.Handler exposing the measurements of the given collector in the
+// Prometheus text format. Each metric is labeled with the stage ID, its name and the ID of the
+// stage containing it (see Name).
+func MetricsHandler(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		buf := bufio.NewWriter(w)
+		defer buf.Flush()
+		writeMetrics(buf, c.Stages(), c.Snapshot())
+	})
+}
+
+type metricDesc struct {
+	name, kind, help string
+	value            func(m StageMetrics) float64
+}
+
+var stageMetricDescs = []metricDesc{
+	{"pipeline_stage_items_in_total", "counter", "Number of items received by the stage.",
+		func(m StageMetrics) float64 { return float64(m.ItemsIn) }},
+	{"pipeline_stage_items_out_total", "counter", "Number of items sent by the stage.",
+		func(m StageMetrics) float64 { return float64(m.ItemsOut) }},
+	{"pipeline_stage_items_dropped_total", "counter", "Number of items dropped by the stage.",
+		func(m StageMetrics) float64 { return float64(m.ItemsDropped) }},
+	{"pipeline_stage_send_blocked_seconds_total", "counter", "Total time spent to send the items to the next stage.",
+		func(m StageMetrics) float64 { return m.SendBlockedTime.Seconds() }},
+	{"pipeline_stage_queue_depth", "gauge", "Last known number of items in the output channel of the stage.",
+		func(m StageMetrics) float64 { return float64(m.QueueDepth) }},
+	{"pipeline_stage_max_queue_depth", "gauge", "Highest number of items seen in the output channel of the stage.",
+		func(m StageMetrics) float64 { return float64(m.MaxQueueDepth) }},
+	{"pipeline_stage_active_workers", "gauge", "Number of running workers of the stage.",
+		func(m StageMetrics) float64 { return float64(m.ActiveWorkers) }},
+}
+
+const (
+	counterMetric = "pipeline_stage_counter_total"
+	latencyMetric = "pipeline_stage_processing_seconds"
+)
+
+func writeMetrics(w *bufio.Writer, stages []string, snapshot map[string]StageMetrics) {
+	for _, desc := range stageMetricDescs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", desc.name, desc.help, desc.name, desc.kind)
+		for _, stage := range stages {
+			fmt.Fprintf(w, "%s{%s} %s\n", desc.name, stageLabels(stage), formatFloat(desc.value(snapshot[stage])))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP %s Named counters of the stage.\n# TYPE %s counter\n", counterMetric, counterMetric)
+	for _, stage := range stages {
+		counters := snapshot[stage].Counters
+		names := make([]string, 0, len(counters))
+		for name := range counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{%s,counter=\"%s\"} %d\n", counterMetric, stageLabels(stage), labelEscaper.Replace(name), counters[name])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP %s Time spent to process the items.\n# TYPE %s histogram\n", latencyMetric, latencyMetric)
+	for _, stage := range stages {
+		metrics, labels := snapshot[stage], stageLabels(stage)
+
+		var count uint64
+		for i, bound := range metrics.Latency.Bounds {
+			count += metrics.Latency.Counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", latencyMetric, labels, formatFloat(bound.Seconds()), count)
+		}
+		if n := len(metrics.Latency.Counts); n > 0 {
+			count += metrics.Latency.Counts[n-1]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", latencyMetric, labels, count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", latencyMetric, labels, formatFloat(metrics.ProcessingTime.Seconds()))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", latencyMetric, labels, count)
+	}
+}
+
+// stageLabels returns the Prometheus labels of the given stage ID.
+func stageLabels(stage string) string {
+	parent, name := "", stage
+	if i := strings.LastIndex(stage, "/"); i >= 0 {
+		parent, name = stage[:i], stage[i+1:]
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if _, err := strconv.Atoi(name[:i]); err == nil {
+			name = name[i+1:]
+		}
+	}
+	return fmt.Sprintf(`stage="%s",name="%s",parent="%s"`, labelEscaper.Replace(stage), labelEscaper.Replace(name), labelEscaper.Replace(parent))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
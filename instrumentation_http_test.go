@@ -0,0 +1,71 @@
+package pipeline_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(2, pipeline.Pipeline{
+			pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.Name("noop")),
+		}),
+	}
+
+	collector := pipeline.NewCollector(time.Millisecond, time.Hour)
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithInstrumentation(collector))
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+
+	server := httptest.NewServer(pipeline.MetricsHandler(collector))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Contains(t, string(body), "# TYPE pipeline_stage_items_in_total counter\n")
+	assert.Contains(t, string(body), `pipeline_stage_items_in_total{stage="0.parallelize",name="parallelize",parent=""} 4`+"\n")
+	assert.Contains(t, string(body), `pipeline_stage_items_out_total{stage="0.parallelize/0.noop",name="noop",parent="0.parallelize"} 4`+"\n")
+	assert.Contains(t, string(body), "# TYPE pipeline_stage_active_workers gauge\n")
+	assert.Contains(t, string(body), `pipeline_stage_active_workers{stage="0.parallelize",name="parallelize",parent=""} 0`+"\n")
+	assert.Contains(t, string(body), "# TYPE pipeline_stage_processing_seconds histogram\n")
+	assert.Contains(t, string(body), `pipeline_stage_processing_seconds_bucket{stage="0.parallelize/0.noop",name="noop",parent="0.parallelize",le="3600"} 4`+"\n")
+	assert.Contains(t, string(body), `pipeline_stage_processing_seconds_bucket{stage="0.parallelize/0.noop",name="noop",parent="0.parallelize",le="+Inf"} 4`+"\n")
+	assert.Contains(t, string(body), `pipeline_stage_processing_seconds_count{stage="0.parallelize/0.noop",name="noop",parent="0.parallelize"} 4`+"\n")
+}
+
+func TestMetricsHandler_Counters(t *testing.T) {
+	collector := pipeline.NewCollector()
+	collector.Count("0.router", "default")
+	collector.Count("0.router", "default")
+	collector.Count("0.router", `a"b`)
+
+	rec := httptest.NewRecorder()
+	pipeline.MetricsHandler(collector).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Contains(t, rec.Body.String(), "# TYPE pipeline_stage_counter_total counter\n"+
+		`pipeline_stage_counter_total{stage="0.router",name="router",parent="",counter="a\"b"} 1`+"\n"+
+		`pipeline_stage_counter_total{stage="0.router",name="router",parent="",counter="default"} 2`+"\n")
+}
@@ -0,0 +1,178 @@
+package pipeline_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestCollector(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { time.Sleep(time.Millisecond); return obj }, pipeline.Name("sleep")),
+		pipeline.LRFilter(
+			func(i interface{}) bool { return i.(int) < 0 },
+			pipeline.Pipeline{
+				pipeline.CE(func(obj interface{}) (interface{}, error) { return nil, errOdd }),
+			},
+			pipeline.Pipeline{
+				pipeline.C(func(obj interface{}) interface{} { return obj }),
+			},
+		),
+	}
+
+	collector := pipeline.NewCollector()
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in,
+		pipeline.WithInstrumentation(collector),
+		pipeline.WithErrorMode(pipeline.ContinueOnError),
+	)
+
+	go func() {
+		for _, i := range []int{1, -1, 2, 3} {
+			in <- i
+		}
+		close(in)
+	}()
+	go func() {
+		for range exec.Errors() {
+		}
+	}()
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+
+	assert.Equal(t, []string{
+		"0.sleep",
+		"1.lrfilter",
+		"1.lrfilter/left/0.consumer",
+		"1.lrfilter/right/0.consumer",
+	}, collector.Stages())
+
+	snapshot := collector.Snapshot()
+	assert.Equal(t, uint64(4), snapshot["0.sleep"].ItemsIn)
+	assert.Equal(t, uint64(4), snapshot["0.sleep"].ItemsOut)
+	assert.Equal(t, uint64(4), snapshot["0.sleep"].ItemsProcessed)
+	assert.True(t, snapshot["0.sleep"].ProcessingTime >= 4*time.Millisecond)
+	assert.Equal(t, uint64(4), snapshot["1.lrfilter"].ItemsIn)
+	assert.Equal(t, uint64(3), snapshot["1.lrfilter"].ItemsOut)
+	assert.Equal(t, uint64(1), snapshot["1.lrfilter/left/0.consumer"].ItemsIn)
+	assert.Equal(t, uint64(0), snapshot["1.lrfilter/left/0.consumer"].ItemsOut)
+	assert.Equal(t, uint64(1), snapshot["1.lrfilter/left/0.consumer"].ItemsDropped)
+	assert.Equal(t, uint64(3), snapshot["1.lrfilter/right/0.consumer"].ItemsOut)
+}
+
+func TestCollector_Mirror(t *testing.T) {
+	lock := make(chan interface{})
+	defer close(lock)
+
+	p := pipeline.Pipeline{
+		pipeline.Mirror(
+			pipeline.C(func(obj interface{}) interface{} { return obj }),
+			pipeline.C(func(obj interface{}) interface{} { <-lock; return obj }),
+		),
+	}
+
+	collector := pipeline.NewCollector()
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithInstrumentation(collector))
+
+	for i := 0; i < 5; i++ {
+		in <- i
+		assert.Equal(t, i, <-exec.Out())
+	}
+	exec.Cancel()
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+
+	snapshot := collector.Snapshot()
+	assert.Equal(t, uint64(5), snapshot["0.mirror"].ItemsIn)
+	assert.Equal(t, uint64(5), snapshot["0.mirror"].ItemsOut)
+	assert.True(t, snapshot["0.mirror"].ItemsDropped > 0)
+	assert.Equal(t, uint64(5), snapshot["0.mirror/0.consumer"].ItemsOut)
+}
+
+func TestCollector_ActiveWorkers(t *testing.T) {
+	lock, started := make(chan interface{}), make(chan interface{})
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(3, pipeline.C(func(obj interface{}) interface{} { started <- obj; <-lock; return obj })),
+	}
+
+	collector := pipeline.NewCollector()
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithInstrumentation(collector))
+
+	for i := 0; i < 3; i++ {
+		in <- i
+		<-started // each worker is locked by an item
+	}
+	assert.Equal(t, 3, collector.Snapshot()["0.parallelize"].ActiveWorkers)
+
+	close(lock)
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, 0, collector.Snapshot()["0.parallelize"].ActiveWorkers)
+}
+
+// sendRecorder is a Collector counting the calls to SendBlocked and QueueDepth of each stage.
+type sendRecorder struct {
+	*pipeline.Collector
+
+	lock                    sync.Mutex
+	sendBlocked, queueDepth map[string]int
+}
+
+func (r *sendRecorder) SendBlocked(stage string, blocked time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sendBlocked[stage]++
+}
+
+func (r *sendRecorder) QueueDepth(stage string, depth int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.queueDepth[stage]++
+}
+
+func TestCollector_BranchSends(t *testing.T) {
+	identity := func() pipeline.Pipeline {
+		return pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj })}
+	}
+	isEven := func(i interface{}) bool { return i.(int)%2 == 0 }
+
+	tcases := []struct {
+		name  string
+		stage pipeline.Stage
+		sends int // sends is the number of sends for each item (to a branch and to the output)
+	}{
+		{"lrfilter", pipeline.LRFilter(isEven, identity(), identity()), 2},
+		{"fork", pipeline.Fork(identity(), identity()), 4},
+		{"partition", pipeline.PartitionBy(2, func(item interface{}) interface{} { return item }, identity()), 2},
+		{"router", pipeline.Router([]pipeline.Route{{Predicate: isEven, Pipeline: identity()}}, identity()), 2},
+	}
+
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			recorder := &sendRecorder{Collector: pipeline.NewCollector(), sendBlocked: map[string]int{}, queueDepth: map[string]int{}}
+			in := make(chan interface{})
+			exec := pipeline.Pipeline{tcase.stage}.Start(context.Background(), in, pipeline.WithInstrumentation(recorder))
+
+			go func() {
+				for i := 0; i < 4; i++ {
+					in <- i
+				}
+				close(in)
+			}()
+			assertClosed(t, exec.Out(), 100*time.Millisecond)
+			assert.NoError(t, exec.Wait())
+
+			stage := "0." + tcase.name
+			recorder.lock.Lock()
+			defer recorder.lock.Unlock()
+			assert.Equal(t, 4*tcase.sends, recorder.sendBlocked[stage])
+			assert.Equal(t, 4*tcase.sends, recorder.queueDepth[stage])
+		})
+	}
+}
@@ -1,22 +1,51 @@
 package pipeline
 
+import "context"
+
 // Pipeline is a sequence of stages executed concurrently.
 type Pipeline []Stage
 
 // Run start all stages. The pipeline can be stopped if the given channel is closed (or by the
 // producer if a producer is used).
 func (p Pipeline) Run(inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return p.RunContext(context.Background(), inCh)
+}
+
+// RunContext start all stages. The pipeline can be stopped if the given channel is closed, by the
+// producer if a producer is used, or if the given context is done. In this case, all stages close
+// their output channels and drain their input channels.
+func (p Pipeline) RunContext(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return untraceChan(ctx, p.runTraced(ctx, inCh))
+}
+
+// runTraced starts all stages like RunContext, but sends the traced items to the output channel.
+func (p Pipeline) runTraced(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{}) {
 	if len(p) == 0 || hasNilStage(p) {
 		return inCh
 	}
 
 	ch := inCh
-	for _, stage := range p {
-		ch = stage.Run(ch)
+	for i, stage := range p {
+		ch = runStage(withStageIndex(ctx, i), stage, ch)
 	}
 	return ch
 }
 
+// Start starts all stages like RunContext and returns the running pipeline. Errors reported by the
+// stages are handled depending on the given options (see RunOption).
+func (p Pipeline) Start(ctx context.Context, inCh <-chan interface{}, opts ...RunOption) *Execution {
+	exec := &Execution{done: make(chan struct{}), bufferSize: BufferedChanSize}
+	for _, opt := range opts {
+		opt(exec)
+	}
+	exec.out = make(chan interface{}, exec.bufferSize)
+	exec.errs = make(chan *ItemError, exec.bufferSize)
+
+	exec.ctx, exec.cancel = context.WithCancel(ctx)
+	go exec.run(p.RunContext(context.WithValue(exec.ctx, executionKey{}, exec), inCh))
+	return exec
+}
+
 func hasNilStage(stages []Stage) bool {
 	for _, stage := range stages {
 		if stage == nil {
@@ -1,8 +1,11 @@
 package pipeline_test
 
 import (
+	"context"
 	"math/rand"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -47,3 +50,84 @@ func TestPipeline_NilStage(t *testing.T) {
 
 	assert.Equal(t, (<-chan interface{})(in), out)
 }
+
+func TestPipeline_RunContext(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.ProducerContext(func(ctx context.Context, _ <-chan interface{}) <-chan interface{} {
+			out := make(chan interface{})
+			go func() {
+				defer close(out)
+				for i := 0; ; i++ {
+					select {
+					case out <- i:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return out
+		}),
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+	}
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	out := p.RunContext(ctx, nil)
+
+	assert.Equal(t, 0, <-out)
+	assert.Equal(t, 2, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-goroutines)
+		}
+	}
+}
+
+func TestPipeline_RunContext_Stage(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.StageFnc(func(in <-chan interface{}) <-chan interface{} {
+			out := make(chan interface{})
+			go func() {
+				defer close(out)
+				for v := range in {
+					out <- v
+				}
+			}()
+			return out
+		}),
+	}
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := p.RunContext(ctx, in)
+
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	select {
+	case in <- 1: // the input channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must not be blocked")
+	}
+}
+
+// assertClosed drains the given channel and fails if it is not closed before the timeout.
+func assertClosed(t *testing.T, ch <-chan interface{}, timeout time.Duration) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case _, open := <-ch:
+			if !open {
+				return
+			}
+		case <-deadline:
+			t.Errorf("channel must be closed before %s", timeout)
+			return
+		}
+	}
+}
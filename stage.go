@@ -1,8 +1,67 @@
 package pipeline
 
+import "context"
+
 // BufferedChanSize is the size of each buffered channel. This can be change globally for all stages.
+// Prefer the BufferSize stage option or the WithBufferSize run option to configure a single stage
+// or a single pipeline.
 var BufferedChanSize = 32
 
+// StageOption configures a built-in stage.
+type StageOption func(*stageConfig)
+
+type stageConfig struct {
+	name          string
+	bufferSize    int
+	hasBufferSize bool
+	dropped       chan<- interface{}
+	eventTime     *EventTimeConfig
+}
+
+func newStageConfig(opts []StageOption) stageConfig {
+	cfg := stageConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Name defines the name of the stage, used to identify it in errors and measurements. By default, a
+// stage is named by its kind ("consumer", "fork", ...).
+func Name(name string) StageOption { return func(cfg *stageConfig) { cfg.name = name } }
+
+// BufferSize defines the capacity of each channel created by the stage (unbuffered if negative).
+// Without this option, the capacity defined by WithBufferSize is used, or a capacity depending on
+// the stage otherwise.
+func BufferSize(size int) StageOption {
+	return func(cfg *stageConfig) { cfg.bufferSize, cfg.hasBufferSize = nonNegative(size), true }
+}
+
+func nonNegative(size int) int {
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// Dropped defines a channel receiving the items dropped by a stage discarding items on purpose
+// (Filter, Reject, RateLimit, CircuitBreaker, Timeout, Join or Dedup), in order to audit them. The
+// stage blocks until the items are received and never closes the channel, which can be shared
+// between several stages.
+func Dropped(ch chan<- interface{}) StageOption { return func(cfg *stageConfig) { cfg.dropped = ch } }
+
+// chanSize returns the capacity of the channels created by the stage. The default capacity is used
+// if neither the stage nor the pipeline defines it.
+func (cfg stageConfig) chanSize(ctx context.Context, defaultSize int) int {
+	if cfg.hasBufferSize {
+		return cfg.bufferSize
+	}
+	if exec := executionFrom(ctx); exec != nil && exec.hasBufferSize {
+		return exec.bufferSize
+	}
+	return defaultSize
+}
+
 // Stage is a step executed in parallel on sequentially and composing a pipeline. This is the main
 // component of this package.
 // IMPORTANT: The stage must close the output channel when the input channel is closed (to propagate
@@ -11,7 +70,89 @@ type Stage interface {
 	Run(inCh <-chan interface{}) (outCh <-chan interface{})
 }
 
+// ContextStage is a stage that can be stopped through a context. When the context is done, the stage
+// must stop its goroutines, close its output channel and drain its input channel.
+type ContextStage interface {
+	Stage
+	RunContext(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{})
+}
+
 // StageFnc is a generic function that implements the stage interface.
 type StageFnc func(inCh <-chan interface{}) (outCh <-chan interface{})
 
 func (fnc StageFnc) Run(inCh <-chan interface{}) (outCh <-chan interface{}) { return fnc(inCh) }
+
+// StageCtxFnc is a generic function that implements the context stage interface.
+type StageCtxFnc func(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{})
+
+func (fnc StageCtxFnc) Run(inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return fnc(context.Background(), inCh)
+}
+func (fnc StageCtxFnc) RunContext(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return fnc(ctx, inCh)
+}
+
+// builtinStage is a context stage implemented by this package. When the pipeline is traced, the
+// built-in stages exchange traced items (see WithTracer); other stages only receive their values.
+type builtinStage func(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{})
+
+func (fnc builtinStage) Run(inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return fnc.RunContext(context.Background(), inCh)
+}
+func (fnc builtinStage) RunContext(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return untraceChan(ctx, fnc(ctx, inCh))
+}
+func (fnc builtinStage) runTraced(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{}) {
+	return fnc(ctx, inCh)
+}
+
+// tracedStage is a stage sending and receiving traced items.
+type tracedStage interface {
+	runTraced(ctx context.Context, inCh <-chan interface{}) (outCh <-chan interface{})
+}
+
+// runStage runs the given stage with the given context. Stages which are not context aware are
+// isolated behind channels bound to the context, in order to stop them when the context is done.
+func runStage(ctx context.Context, stage Stage, inCh <-chan interface{}) <-chan interface{} {
+	if stage, isTraced := stage.(tracedStage); isTraced {
+		return stage.runTraced(ctx, inCh)
+	}
+
+	inCh = untraceChan(ctx, inCh)
+	if stage, isCtxStage := stage.(ContextStage); isCtxStage {
+		return stage.RunContext(ctx, inCh)
+	}
+	if ctx.Done() == nil {
+		return stage.Run(inCh)
+	}
+	return bindChan(ctx, stage.Run(bindChan(ctx, inCh)))
+}
+
+// runProtected runs the given stage like runStage, but recovers the panics raised when the stage is
+// started (see Protect). If the stage can't be started, its input channel is flushed and nil is
+// returned.
+func runProtected(ctx context.Context, name string, stage Stage, inCh <-chan interface{}) <-chan interface{} {
+	var run func() <-chan interface{}
+	switch s := stage.(type) {
+	case tracedStage:
+		run = func() <-chan interface{} { return s.runTraced(ctx, inCh) }
+	case ContextStage:
+		inCh = untraceChan(ctx, inCh)
+		run = func() <-chan interface{} { return s.RunContext(ctx, inCh) }
+	default:
+		// the input channel is bound only once, to avoid losing values when the stage is restarted
+		inCh = bindChan(ctx, untraceChan(ctx, inCh))
+		run = func() <-chan interface{} { return bindChan(ctx, stage.Run(inCh)) }
+	}
+
+	for {
+		var outCh <-chan interface{}
+		ok, policy := Protect(ctx, name, nil, func() { outCh = run() })
+		if ok {
+			return outCh
+		} else if policy != PanicRestart {
+			go flushChan(inCh)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pipeline
+
+import "context"
+
+// Accumulator returns the new accumulated value of a Scan, Reduce or GroupBy stage, from the
+// previous one and the given item.
+type Accumulator func(acc, obj interface{}) interface{}
+
+// Scan accumulates the values of the input channel with the given function, starting with the
+// initial value, and sends the accumulated value after each item.
+func Scan(initial interface{}, fnc Accumulator, opts ...StageOption) Stage {
+	return fold("scan", initial, fnc, true, opts)
+}
+
+// Reduce accumulates the values of the input channel like Scan, but sends only the final
+// accumulated value (or the initial one without item) when the input channel is closed. Nothing is
+// sent if the pipeline is stopped.
+func Reduce(initial interface{}, fnc Accumulator, opts ...StageOption) Stage {
+	return fold("reduce", initial, fnc, false, opts)
+}
+
+// fold accumulates the values with fnc and sends the accumulated value after each item if each is
+// true, or when the input channel is closed otherwise.
+func fold(kind string, initial interface{}, fnc Accumulator, each bool, opts []StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if fnc == nil || in == nil {
+			return in
+		}
+
+		r := cfg.start(ctx, kind)
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(out)
+			defer flushOnCancel(ctx, in)
+
+			acc := initial
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					if !each && ctx.Err() == nil {
+						r.send(out, acc)
+					}
+					return
+				}
+
+				var next interface{}
+				ok, policy := r.process(span, value, func() { next = fnc(acc, value) })
+				span.end(nil)
+				switch {
+				case !ok && policy == PanicAbort:
+					go flushChan(in)
+					return
+				case !ok:
+					continue
+				}
+
+				acc = next
+				if each && !r.send(out, span.wrap(acc)) {
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
+
+// Group is a key and its accumulated value, sent by GroupBy.
+type Group struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// GroupBy accumulates the values of the input channel of each key like Reduce, starting with the
+// initial value for each key. When the input channel is closed, it sends a Group for each key, in
+// the order of their first item. Nothing is sent if the pipeline is stopped. The keys must be
+// comparable.
+func GroupBy(key func(item interface{}) interface{}, initial interface{}, fnc Accumulator, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if key == nil || fnc == nil || in == nil {
+			return in
+		}
+
+		r := cfg.start(ctx, "groupby")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(out)
+			defer flushOnCancel(ctx, in)
+
+			var groups []*Group
+			indexes := map[interface{}]int{}
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					break
+				}
+
+				ok, policy := r.process(span, value, func() {
+					k := key(value)
+					i, exists := indexes[k]
+					if exists {
+						groups[i].Value = fnc(groups[i].Value, value)
+						return
+					}
+
+					acc := fnc(initial, value)
+					indexes[k] = len(groups)
+					groups = append(groups, &Group{Key: k, Value: acc})
+				})
+				span.end(nil)
+				if !ok && policy == PanicAbort {
+					go flushChan(in)
+					return
+				}
+			}
+
+			for _, group := range groups {
+				if ctx.Err() != nil || !r.send(out, *group) {
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
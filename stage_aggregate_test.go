@@ -0,0 +1,86 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func sum(acc, obj interface{}) interface{} { return acc.(int) + obj.(int) }
+
+func TestScan(t *testing.T) {
+	out := pipeline.Scan(0, sum).Run(filledChan(1, 2, 3))
+
+	values, err := pipeline.Collect(context.Background(), out)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, 3, 6}, values)
+}
+
+func TestReduce(t *testing.T) {
+	out := pipeline.Reduce(0, sum).Run(filledChan(1, 2, 3))
+
+	values, err := pipeline.Collect(context.Background(), out)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{6}, values)
+}
+
+func TestReduce_Empty(t *testing.T) {
+	out := pipeline.Reduce(0, sum).Run(filledChan())
+
+	values, err := pipeline.Collect(context.Background(), out)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{0}, values)
+}
+
+func TestReduce_Panic(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Reduce(0, func(acc, obj interface{}) interface{} {
+			if obj.(int) == 2 {
+				panic("unexpected value")
+			}
+			return sum(acc, obj)
+		}),
+	}
+	exec := p.Start(context.Background(), filledChan(1, 2, 3), pipeline.WithPanicPolicy(pipeline.PanicDrop))
+
+	values, err := pipeline.Collect(context.Background(), exec.Out())
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{4}, values)
+}
+
+func TestReduce_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan interface{})
+	out := pipeline.Reduce(0, sum).(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	cancel() // nothing is sent
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestGroupBy(t *testing.T) {
+	out := pipeline.GroupBy(
+		func(item interface{}) interface{} { return item.(int) % 3 },
+		0,
+		sum,
+	).Run(filledChan(1, 2, 3, 4, 5, 6, 7))
+
+	values, err := pipeline.Collect(context.Background(), out)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		pipeline.Group{Key: 1, Value: 12},
+		pipeline.Group{Key: 2, Value: 7},
+		pipeline.Group{Key: 0, Value: 9},
+	}, values)
+}
+
+func TestGroupBy_NilKey(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.GroupBy(nil, 0, sum).Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// BatchConfig defines when a Batch stage sends its current batch. A limit set to zero is disabled.
+type BatchConfig struct {
+	MaxSize   int                        // MaxSize is the maximum number of items of a batch
+	MaxWeight int                        // MaxWeight is the maximum total weight of a batch (ignored without Sizer)
+	Sizer     func(item interface{}) int // Sizer returns the weight of an item (in bytes for example)
+	MaxLinger time.Duration              // MaxLinger is the maximum time the first item of a batch waits before being sent
+}
+
+// Batch collects the values of the input channel into []interface{} batches, which are sent when
+// one of the configured limits is reached. An item heavier than MaxWeight is sent in its own batch.
+// The current batch is sent when the input channel is closed, but is dropped if the pipeline is
+// stopped.
+func Batch(config BatchConfig, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if inCh == nil {
+			return nil
+		}
+
+		r := cfg.start(ctx, "batch")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			b := &batcher{config: config, r: r, outCh: outCh}
+			defer b.stopTimer()
+
+			for {
+				select {
+				case in, open := <-inCh:
+					if !open {
+						b.flush()
+						return
+					}
+					if !b.add(in) {
+						go flushChan(inCh)
+						return
+					}
+				case <-b.linger:
+					if !b.flush() {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
+
+// batcher is the current batch of a Batch stage.
+type batcher struct {
+	config BatchConfig
+	r      *stageRuntime
+	outCh  chan<- interface{}
+
+	items  []interface{}
+	weight int
+	timer  Timer
+	linger <-chan time.Time
+}
+
+// add adds the given input to the batch, which is sent if a limit is reached. It returns false if
+// the stage must be stopped (if the pipeline is stopped or if Sizer panicked with PanicAbort).
+func (b *batcher) add(in interface{}) bool {
+	value, span := b.r.received(in)
+	defer span.end(nil)
+
+	weight := 0
+	if b.config.MaxWeight > 0 && b.config.Sizer != nil {
+		ok, policy := b.r.process(span, value, func() { weight = b.config.Sizer(value) })
+		if !ok {
+			return policy != PanicAbort
+		}
+		if len(b.items) > 0 && b.weight+weight > b.config.MaxWeight && !b.flush() {
+			return false
+		}
+	}
+
+	b.items = append(b.items, value)
+	b.weight += weight
+	if len(b.items) == 1 && b.config.MaxLinger > 0 {
+		b.timer = b.r.clock.NewTimer(b.config.MaxLinger)
+		b.linger = b.timer.C()
+	}
+
+	if (b.config.MaxSize > 0 && len(b.items) >= b.config.MaxSize) ||
+		(b.config.MaxWeight > 0 && b.config.Sizer != nil && b.weight >= b.config.MaxWeight) {
+		return b.flush()
+	}
+	return true
+}
+
+// flush sends the batch (if not empty) and starts a new one. It returns false if the stage must be
+// stopped.
+func (b *batcher) flush() bool {
+	b.stopTimer()
+	if len(b.items) == 0 {
+		return true
+	}
+
+	items := b.items
+	b.items, b.weight = nil, 0
+	return b.r.send(b.outCh, items)
+}
+
+func (b *batcher) stopTimer() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer, b.linger = nil, nil
+	}
+}
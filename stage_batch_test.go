@@ -0,0 +1,128 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestBatch_MaxSize(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Batch(pipeline.BatchConfig{MaxSize: 2}).Run(in)
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	assert.Equal(t, []interface{}{0, 1}, <-out)
+	assert.Equal(t, []interface{}{2, 3}, <-out)
+	assert.Equal(t, []interface{}{4}, <-out) // partial batch sent when the input is closed
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestBatch_MaxWeight(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Batch(pipeline.BatchConfig{
+		MaxWeight: 10,
+		Sizer:     func(item interface{}) int { return len(item.(string)) },
+	}).Run(in)
+
+	go func() {
+		for _, s := range []string{"aaaa", "bbbb", "cccc", "dddddddddddd", "ee", "ffffffff"} {
+			in <- s
+		}
+		close(in)
+	}()
+
+	assert.Equal(t, []interface{}{"aaaa", "bbbb"}, <-out)
+	assert.Equal(t, []interface{}{"cccc"}, <-out)
+	assert.Equal(t, []interface{}{"dddddddddddd"}, <-out)
+	assert.Equal(t, []interface{}{"ee", "ffffffff"}, <-out)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestBatch_MaxLinger(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Batch(pipeline.BatchConfig{MaxSize: 10, MaxLinger: 20 * time.Millisecond}).Run(in)
+
+	start := time.Now()
+	in <- 0
+	in <- 1
+	assert.Equal(t, []interface{}{0, 1}, <-out)
+	assert.WithinDuration(t, start.Add(20*time.Millisecond), time.Now(), 10*time.Millisecond)
+
+	in <- 2
+	close(in)
+	assert.Equal(t, []interface{}{2}, <-out)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestBatch_Parallelize(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(3, pipeline.Batch(pipeline.BatchConfig{MaxSize: 4})),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+	go func() {
+		for i := 0; i < 20; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	var items []interface{}
+	for batch := range exec.Out() {
+		assert.True(t, len(batch.([]interface{})) <= 4)
+		items = append(items, batch.([]interface{})...)
+	}
+	assert.NoError(t, exec.Wait())
+	assert.Len(t, items, 20)
+}
+
+func TestBatch_Fork(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Fork(
+		pipeline.Batch(pipeline.BatchConfig{MaxSize: 2}),
+		pipeline.Batch(pipeline.BatchConfig{MaxSize: 3}),
+	).Run(in)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	var batches []interface{}
+	for batch := range out {
+		batches = append(batches, batch)
+	}
+	assert.ElementsMatch(t, []interface{}{
+		[]interface{}{0, 1}, []interface{}{2},
+		[]interface{}{0, 1, 2},
+	}, batches)
+}
+
+func TestBatch_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan interface{})
+	out := pipeline.Pipeline{pipeline.Batch(pipeline.BatchConfig{MaxSize: 10})}.RunContext(ctx, in)
+
+	in <- 0
+	cancel()
+	assertClosed(t, out, 100*time.Millisecond)
+
+	select {
+	case in <- 1: // the input channel is drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must be drained")
+	}
+}
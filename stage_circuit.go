@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a CircuitBreaker stage.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all items go through the stage.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all items, until the open timeout expires.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial items go through the stage; the circuit is closed if they
+	// succeed, and opened again otherwise.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig defines when a CircuitBreaker stage opens its circuit and what happens to the
+// rejected items. A threshold set to zero is disabled.
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // FailureThreshold is the number of consecutive failures opening the circuit
+	ErrorRate        float64       // ErrorRate is the rate of failures (between 0 and 1) opening the circuit
+	Window           int           // Window is the number of last calls used to compute the error rate (10 by default)
+	OpenTimeout      time.Duration // OpenTimeout is the time before an open circuit becomes half-open
+	HalfOpenCalls    int           // HalfOpenCalls is the number of successful trials closing the circuit (1 by default)
+
+	// Fallback is the pipeline receiving the rejected items; its outputs are sent to the next stage.
+	Fallback Pipeline
+	// Placeholder returns the value sent to the next stage instead of a rejected item. Without
+	// Fallback and Placeholder, the rejected items are dropped (see Dropped).
+	Placeholder func(obj interface{}) interface{}
+	// OnStateChange is called each time the state of the circuit changes.
+	OnStateChange func(from, to CircuitState)
+}
+
+// CircuitBreaker is a ConsumerE which stops calling the given function when it fails too often, as
+// configured. The state of the circuit is shared by all runs of the stage (like the Parallelize
+// workers) and depends on the pipeline clock (see WithClock).
+func CircuitBreaker(fnc func(obj interface{}) (interface{}, error), config CircuitBreakerConfig, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	breaker := newCircuit(config)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if fnc == nil || inCh == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, "circuitbreaker")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+
+		wg := &sync.WaitGroup{}
+		var fallbackCh chan interface{}
+		if len(config.Fallback) > 0 {
+			fallbackCh = make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+			wg.Add(1)
+			go runPipeline(r.scope("fallback"), r, config.Fallback, wg, fallbackCh, outCh)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if fallbackCh != nil {
+				defer close(fallbackCh)
+			}
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, span, open := r.recv(inCh)
+				if !open {
+					return
+				}
+
+				generation, allowed := breaker.allow(r.clock.Now())
+				if !allowed {
+					ok := rejectItem(r, cfg, config, fallbackCh, outCh, span, in)
+					span.end(nil)
+					if !ok {
+						return
+					}
+					continue
+				}
+
+				var out interface{}
+				var err error
+				ok, policy := r.process(span, in, func() { out, err = fnc(in) })
+				breaker.done(generation, ok && err == nil, r.clock.Now())
+				span.end(err)
+				switch {
+				case !ok && policy == PanicAbort:
+					go flushChan(inCh)
+					return
+				case !ok:
+					continue
+				case err != nil:
+					r.reportError(in, err)
+					continue
+				}
+				if !r.send(outCh, span.wrap(out)) {
+					return
+				}
+			}
+		}()
+		go func() { wg.Wait(); close(outCh) }() // Close out only when all goroutine are stopped
+		return outCh
+	})
+}
+
+// rejectItem sends an item rejected by an open circuit to the fallback pipeline, sends its
+// placeholder or drops it. It returns false if the stage must be stopped.
+func rejectItem(r *stageRuntime, cfg stageConfig, config CircuitBreakerConfig, fallbackCh, outCh chan interface{}, span *itemSpan, in interface{}) bool {
+	switch {
+	case fallbackCh != nil:
+		return sendContext(r.ctx, fallbackCh, span.wrap(in))
+	case config.Placeholder != nil:
+		var out interface{}
+		ok, policy := r.process(span, in, func() { out = config.Placeholder(in) })
+		if !ok {
+			return policy != PanicAbort
+		}
+		return r.send(outCh, span.wrap(out))
+	}
+
+	r.drop()
+	return cfg.dropped == nil || sendContext(r.ctx, cfg.dropped, in)
+}
+
+// circuit is the state of a CircuitBreaker stage.
+type circuit struct {
+	config CircuitBreakerConfig
+
+	lock       sync.Mutex
+	state      CircuitState
+	generation uint64    // generation is incremented each time the state changes
+	openedAt   time.Time // openedAt is the time when the circuit has been opened
+	failures   int       // failures is the number of consecutive failures
+	results    []bool    // results are the last results (true on failure) when the circuit is closed
+	trials     int       // trials is the number of running trials when the circuit is half-open
+	successes  int       // successes is the number of successful trials
+}
+
+func newCircuit(config CircuitBreakerConfig) *circuit {
+	if config.Window < 1 {
+		config.Window = 10
+	}
+	if config.HalfOpenCalls < 1 {
+		config.HalfOpenCalls = 1
+	}
+	return &circuit{config: config}
+}
+
+// allow returns true if a call is allowed at the given time, with the generation of the state which
+// allowed it.
+func (c *circuit) allow(now time.Time) (uint64, bool) {
+	c.lock.Lock()
+	var changed func()
+	defer func() {
+		c.lock.Unlock()
+		if changed != nil {
+			changed()
+		}
+	}()
+
+	if c.state == CircuitOpen && !now.Before(c.openedAt.Add(c.config.OpenTimeout)) {
+		changed = c.setState(CircuitHalfOpen, now)
+	}
+
+	switch c.state {
+	case CircuitClosed:
+		return c.generation, true
+	case CircuitHalfOpen:
+		if c.trials+c.successes < c.config.HalfOpenCalls {
+			c.trials++
+			return c.generation, true
+		}
+	}
+	return c.generation, false
+}
+
+// done records the result of a call allowed by the given generation of the state.
+func (c *circuit) done(generation uint64, success bool, now time.Time) {
+	c.lock.Lock()
+	var changed func()
+	defer func() {
+		c.lock.Unlock()
+		if changed != nil {
+			changed()
+		}
+	}()
+
+	if generation != c.generation {
+		return // the call has been allowed by a previous state
+	}
+
+	switch c.state {
+	case CircuitClosed:
+		if success {
+			c.failures = 0
+		} else {
+			c.failures++
+		}
+		if c.config.ErrorRate > 0 {
+			c.results = append(c.results, !success)
+			if len(c.results) > c.config.Window {
+				c.results = c.results[1:]
+			}
+		}
+
+		if (c.config.FailureThreshold > 0 && c.failures >= c.config.FailureThreshold) || c.errorRateExceeded() {
+			changed = c.setState(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		c.trials--
+		if !success {
+			changed = c.setState(CircuitOpen, now)
+		} else if c.successes++; c.successes >= c.config.HalfOpenCalls {
+			changed = c.setState(CircuitClosed, now)
+		}
+	}
+}
+
+func (c *circuit) errorRateExceeded() bool {
+	if c.config.ErrorRate <= 0 || len(c.results) < c.config.Window {
+		return false
+	}
+
+	failures := 0
+	for _, failed := range c.results {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures)/float64(len(c.results)) >= c.config.ErrorRate
+}
+
+// setState changes the state of the circuit and returns the function notifying the change, which
+// must be called once the lock released.
+func (c *circuit) setState(state CircuitState, now time.Time) func() {
+	from := c.state
+	c.state, c.openedAt = state, now
+	c.generation++
+	c.failures, c.results, c.trials, c.successes = 0, nil, 0, 0
+
+	if c.config.OnStateChange == nil {
+		return nil
+	}
+	return func() { c.config.OnStateChange(from, state) }
+}
@@ -0,0 +1,166 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// callDownstream fails for each item equal to "fail".
+func callDownstream(obj interface{}) (interface{}, error) {
+	if obj == "fail" {
+		return nil, errUnavailable
+	}
+	return obj, nil
+}
+
+// stateRecorder records the state changes of a circuit.
+type stateRecorder struct {
+	lock    sync.Mutex
+	changes []string
+}
+
+func (r *stateRecorder) OnStateChange(from, to pipeline.CircuitState) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.changes = append(r.changes, from.String()+" > "+to.String())
+}
+
+func (r *stateRecorder) Changes() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.changes...)
+}
+
+func TestCircuitBreaker_FailureThreshold(t *testing.T) {
+	clock := newFakeClock()
+	states := &stateRecorder{}
+	p := pipeline.Pipeline{
+		pipeline.CircuitBreaker(callDownstream, pipeline.CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      time.Second,
+			Placeholder:      func(obj interface{}) interface{} { return "placeholder" },
+			OnStateChange:    states.OnStateChange,
+		}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock), pipeline.WithErrorMode(pipeline.ContinueOnError))
+
+	in <- "fail"
+	assert.Equal(t, errUnavailable, (<-exec.Errors()).Err)
+	in <- "fail"
+	assert.Equal(t, errUnavailable, (<-exec.Errors()).Err)
+	in <- "ok"
+	assert.Equal(t, "placeholder", <-exec.Out())
+	assert.Equal(t, []string{"closed > open"}, states.Changes())
+
+	clock.Advance(time.Second)
+	in <- "fail" // the trial fails
+	assert.Equal(t, errUnavailable, (<-exec.Errors()).Err)
+	in <- "ok"
+	assert.Equal(t, "placeholder", <-exec.Out())
+
+	clock.Advance(time.Second)
+	in <- "ok" // the trial succeeds
+	assert.Equal(t, "ok", <-exec.Out())
+	in <- "ok"
+	assert.Equal(t, "ok", <-exec.Out())
+	close(in)
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, []string{
+		"closed > open",
+		"open > half-open",
+		"half-open > open",
+		"open > half-open",
+		"half-open > closed",
+	}, states.Changes())
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	dropped := make(chan interface{}, 1)
+	p := pipeline.Pipeline{
+		pipeline.CircuitBreaker(callDownstream, pipeline.CircuitBreakerConfig{
+			ErrorRate:   0.5,
+			Window:      4,
+			OpenTimeout: time.Hour,
+		}, pipeline.Dropped(dropped)),
+	}
+
+	in := make(chan interface{}, 5)
+	for _, item := range []string{"fail", "ok", "fail", "ok", "next"} {
+		in <- item
+	}
+	close(in)
+	exec := p.Start(context.Background(), in, pipeline.WithErrorMode(pipeline.ContinueOnError))
+	go func() {
+		for range exec.Errors() {
+		}
+	}()
+
+	assert.Equal(t, "ok", <-exec.Out())
+	assert.Equal(t, "ok", <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, "next", <-dropped)
+}
+
+func TestCircuitBreaker_Fallback(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.CircuitBreaker(callDownstream, pipeline.CircuitBreakerConfig{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Hour,
+			Fallback: pipeline.Pipeline{
+				pipeline.C(func(obj interface{}) interface{} { return "fallback " + obj.(string) }),
+			},
+		}),
+	}
+
+	in := make(chan interface{}, 3)
+	for _, item := range []string{"fail", "a", "b"} {
+		in <- item
+	}
+	close(in)
+	exec := p.Start(context.Background(), in, pipeline.WithErrorMode(pipeline.ContinueOnError))
+	go func() {
+		for range exec.Errors() {
+		}
+	}()
+
+	assert.Equal(t, "fallback a", <-exec.Out())
+	assert.Equal(t, "fallback b", <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestCircuitBreaker_Parallelize(t *testing.T) {
+	var calls int32
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(3, pipeline.CircuitBreaker(func(obj interface{}) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, errUnavailable
+		}, pipeline.CircuitBreakerConfig{FailureThreshold: 3, OpenTimeout: time.Hour})),
+	}
+
+	in := make(chan interface{}, 20)
+	for i := 0; i < 20; i++ {
+		in <- i
+	}
+	close(in)
+	exec := p.Start(context.Background(), in, pipeline.WithErrorMode(pipeline.ContinueOnError))
+	go func() {
+		for range exec.Errors() {
+		}
+	}()
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&calls) <= 5, "the circuit must be shared by all workers")
+}
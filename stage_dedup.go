@@ -0,0 +1,225 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DedupMode defines how a Dedup stage remembers the seen keys.
+type DedupMode int
+
+const (
+	// DedupExact remembers the seen keys in a cache bounded by MaxKeys, forgetting the least
+	// recently seen keys first.
+	DedupExact DedupMode = iota
+	// DedupBloom remembers the seen keys in Bloom filters sized for MaxKeys keys per TTL. It uses a
+	// fixed amount of memory, but drops a new item with the configured false positive rate.
+	DedupBloom
+)
+
+// DedupConfig defines which items are dropped by a Dedup stage.
+type DedupConfig struct {
+	Key     func(item interface{}) interface{} // Key returns the key of an item (the item itself if nil)
+	TTL     time.Duration                      // TTL is the time during which a seen key is remembered (forever if zero)
+	MaxKeys int                                // MaxKeys is the maximum number of remembered keys (unbounded if zero with DedupExact)
+	Mode    DedupMode
+	// FalsePositiveRate is the probability that DedupBloom drops a new item (0.01 by default).
+	FalsePositiveRate float64
+}
+
+// Dedup drops the items whose key has been seen during the configured TTL (see Dropped). The seen
+// keys are shared by all runs of the stage (like the Parallelize workers) and the time is given by
+// the pipeline clock (see WithClock). The "hits" (dropped items) and "misses" counters of the stage
+// are incremented for each item (see CounterInstrumentation).
+// NOTE: With DedupBloom, a key is remembered between TTL and twice TTL.
+func Dedup(config DedupConfig, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	var seen dedupSet = newDedupCache(config)
+	if config.Mode == DedupBloom {
+		seen = newDedupBloom(config)
+	}
+
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if in == nil {
+			return nil
+		}
+
+		r := cfg.start(ctx, "dedup")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(out)
+			defer flushOnCancel(ctx, in)
+
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					return
+				}
+
+				var duplicated bool
+				ok, policy := r.process(span, value, func() {
+					key := value
+					if config.Key != nil {
+						key = config.Key(value)
+					}
+					duplicated = seen.seen(key, r.clock.Now())
+				})
+				span.end(nil)
+				switch {
+				case !ok && policy == PanicAbort:
+					go flushChan(in)
+					return
+				case !ok:
+					continue
+				case duplicated:
+					r.count("hits")
+					r.drop()
+					if cfg.dropped != nil && !sendContext(ctx, cfg.dropped, value) {
+						return
+					}
+				default:
+					r.count("misses")
+					if !r.send(out, span.wrap(value)) {
+						return
+					}
+				}
+			}
+		}()
+		return out
+	})
+}
+
+// dedupSet remembers the keys seen by a Dedup stage.
+type dedupSet interface {
+	// seen returns true if the given key has already been seen, and remembers it otherwise.
+	seen(key interface{}, now time.Time) bool
+}
+
+// dedupCache is a dedupSet remembering the exact keys, with a least recently seen eviction.
+type dedupCache struct {
+	ttl     time.Duration
+	maxKeys int
+
+	lock  sync.Mutex
+	keys  map[interface{}]*list.Element
+	order *list.List // order are the dedupEntry, the most recently seen first
+	sweep int
+}
+
+type dedupEntry struct {
+	key  interface{}
+	seen time.Time // seen is the time when the key has been remembered
+}
+
+// dedupCacheSweep is the number of calls between two removals of the expired keys.
+const dedupCacheSweep = 1024
+
+func newDedupCache(config DedupConfig) *dedupCache {
+	return &dedupCache{ttl: config.TTL, maxKeys: config.MaxKeys, keys: map[interface{}]*list.Element{}, order: list.New()}
+}
+
+func (c *dedupCache) seen(key interface{}, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removeExpiredKeys(now)
+
+	if element, exists := c.keys[key]; exists {
+		c.order.MoveToFront(element)
+		if !c.expired(element.Value.(*dedupEntry), now) {
+			return true
+		}
+		element.Value.(*dedupEntry).seen = now
+		return false
+	}
+
+	c.keys[key] = c.order.PushFront(&dedupEntry{key: key, seen: now})
+	if c.maxKeys > 0 && c.order.Len() > c.maxKeys {
+		delete(c.keys, c.order.Remove(c.order.Back()).(*dedupEntry).key)
+	}
+	return false
+}
+
+func (c *dedupCache) expired(entry *dedupEntry, now time.Time) bool {
+	return c.ttl > 0 && !now.Before(entry.seen.Add(c.ttl))
+}
+
+// removeExpiredKeys regularly removes the expired keys, in order to not keep all keys ever seen
+// when MaxKeys is not set.
+func (c *dedupCache) removeExpiredKeys(now time.Time) {
+	if c.ttl <= 0 {
+		return
+	} else if c.sweep++; c.sweep < dedupCacheSweep {
+		return
+	}
+
+	c.sweep = 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*dedupEntry); c.expired(entry, now) {
+			c.order.Remove(element)
+			delete(c.keys, entry.key)
+		}
+		element = next
+	}
+}
+
+// dedupBloom is a dedupSet remembering the keys in two Bloom filters: the keys are added to the
+// current one, which replaces the previous one after each TTL.
+type dedupBloom struct {
+	ttl    time.Duration
+	size   uint64 // size is the number of bits of each filter
+	hashes uint64 // hashes is the number of bits set for each key
+
+	lock              sync.Mutex
+	current, previous []uint64
+	rotated           time.Time
+}
+
+func newDedupBloom(config DedupConfig) *dedupBloom {
+	keys, rate := float64(config.MaxKeys), config.FalsePositiveRate
+	if keys <= 0 {
+		keys = 100000
+	}
+	if rate <= 0 || rate >= 1 {
+		rate = 0.01
+	}
+
+	size := math.Ceil(-keys * math.Log(rate) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(size/keys*math.Ln2))
+	words := (uint64(size) + 63) / 64
+	return &dedupBloom{ttl: config.TTL, size: words * 64, hashes: uint64(hashes), current: make([]uint64, words)}
+}
+
+func (b *dedupBloom) seen(key interface{}, now time.Time) bool {
+	hash := hashKey(key)
+	h1, h2 := hash&math.MaxUint32, hash>>32|1
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.rotated.IsZero() {
+		b.rotated = now
+	} else if b.ttl > 0 && !now.Before(b.rotated.Add(b.ttl)) {
+		b.previous, b.current = b.current, make([]uint64, len(b.current))
+		if !now.Before(b.rotated.Add(2 * b.ttl)) {
+			b.previous = nil // the previous filter is expired too
+		}
+		b.rotated = now
+	}
+
+	inCurrent, inPrevious := true, b.previous != nil
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.current[word]&mask == 0 {
+			inCurrent = false
+			b.current[word] |= mask
+		}
+		if inPrevious && b.previous[word]&mask == 0 {
+			inPrevious = false
+		}
+	}
+	return inCurrent || inPrevious
+}
@@ -0,0 +1,113 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+type delivery struct {
+	id      string
+	attempt int
+}
+
+func deliveryID(item interface{}) interface{} { return item.(delivery).id }
+
+func TestDedup(t *testing.T) {
+	clock := newFakeClock()
+	collector := pipeline.NewCollector()
+	dropped := make(chan interface{})
+	p := pipeline.Pipeline{
+		pipeline.Dedup(pipeline.DedupConfig{Key: deliveryID, TTL: time.Minute}, pipeline.Dropped(dropped)),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock), pipeline.WithInstrumentation(collector))
+
+	in <- delivery{"a", 1}
+	assert.Equal(t, delivery{"a", 1}, <-exec.Out())
+	in <- delivery{"b", 1}
+	assert.Equal(t, delivery{"b", 1}, <-exec.Out())
+	in <- delivery{"a", 2}
+	assert.Equal(t, delivery{"a", 2}, <-dropped)
+
+	clock.Advance(time.Minute) // the key "a" is forgotten
+	in <- delivery{"a", 3}
+	assert.Equal(t, delivery{"a", 3}, <-exec.Out())
+
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, map[string]uint64{"hits": 1, "misses": 3}, collector.Snapshot()["0.dedup"].Counters)
+}
+
+func TestDedup_MaxKeys(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.Dedup(pipeline.DedupConfig{MaxKeys: 2})}
+
+	in := make(chan interface{})
+	out := p.Run(in)
+
+	for _, value := range []string{"a", "b", "a", "c", "a"} {
+		in <- value // "c" evicts "b", the least recently seen key
+	}
+	in <- "b"
+	close(in)
+
+	var values []interface{}
+	for value := range out {
+		values = append(values, value)
+	}
+	assert.Equal(t, []interface{}{"a", "b", "c", "b"}, values)
+}
+
+func TestDedup_Bloom(t *testing.T) {
+	clock := newFakeClock()
+	dropped := make(chan interface{})
+	p := pipeline.Pipeline{
+		pipeline.Dedup(pipeline.DedupConfig{Key: deliveryID, TTL: time.Minute, Mode: pipeline.DedupBloom, MaxKeys: 100}, pipeline.Dropped(dropped)),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	in <- delivery{"a", 1}
+	assert.Equal(t, delivery{"a", 1}, <-exec.Out())
+	in <- delivery{"a", 2}
+	assert.Equal(t, delivery{"a", 2}, <-dropped)
+
+	clock.Advance(time.Minute) // the key "a" is still in the previous filter
+	in <- delivery{"b", 1}
+	assert.Equal(t, delivery{"b", 1}, <-exec.Out())
+	in <- delivery{"a", 3}
+	assert.Equal(t, delivery{"a", 3}, <-dropped)
+
+	clock.Advance(2 * time.Minute)
+	in <- delivery{"a", 4}
+	assert.Equal(t, delivery{"a", 4}, <-exec.Out())
+
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestDedup_Parallelize(t *testing.T) {
+	p := pipeline.Parallelize(4, pipeline.Dedup(pipeline.DedupConfig{}))
+
+	in := make(chan interface{})
+	out := p.Run(in)
+	go func() {
+		for i := 0; i < 100; i++ {
+			in <- i % 10
+		}
+		close(in)
+	}()
+
+	var values []interface{}
+	for value := range out {
+		values = append(values, value)
+	}
+	assert.ElementsMatch(t, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, values)
+}
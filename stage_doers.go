@@ -1,43 +1,228 @@
 package pipeline
 
+import "context"
+
 // P is a short alias for Producer
-func P(fnc func(in <-chan interface{}) (out <-chan interface{})) Stage { return Producer(fnc) }
+func P(fnc func(in <-chan interface{}) (out <-chan interface{}), opts ...StageOption) Stage {
+	return Producer(fnc, opts...)
+}
 
 // Producer creates value used by other stages in the pipeline.
-func Producer(fnc func(in <-chan interface{}) <-chan interface{}) Stage {
-	return StageFnc(func(inCh <-chan interface{}) <-chan interface{} {
+// NOTE: When the pipeline context is done, the input channel given to the producer is closed; a
+// producer that ignores its input should use ProducerContext in order to be stopped.
+func Producer(fnc func(in <-chan interface{}) <-chan interface{}, opts ...StageOption) Stage {
+	if fnc == nil {
+		return ProducerContext(nil, opts...)
+	}
+	return ProducerContext(func(_ context.Context, in <-chan interface{}) <-chan interface{} { return fnc(in) }, opts...)
+}
+
+// ProducerContext creates value used by other stages in the pipeline. The given context is done
+// when the pipeline is stopped.
+func ProducerContext(fnc func(ctx context.Context, in <-chan interface{}) <-chan interface{}, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
 		if fnc == nil {
 			return inCh
 		}
 
-		outCh := make(chan interface{}, BufferedChanSize)
+		r := cfg.start(ctx, "producer")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
 		go func() {
 			defer close(outCh)
 
-			for out := range fnc(inCh) {
-				outCh <- out
+			var produced <-chan interface{}
+			if produce(r, inCh, func(inCh <-chan interface{}) { produced = fnc(ctx, inCh) }) {
+				r.emit(produced, outCh)
 			}
 		}()
 		return outCh
 	})
 }
 
+// ProducerE creates value used by other stages in the pipeline by sending them to the given output
+// channel. The output channel is closed when the function returns; the returned error is reported
+// to the pipeline (see Pipeline.Start).
+func ProducerE(fnc func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if fnc == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, "producer")
+		produced := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(produced)
+
+			var err error
+			if produce(r, inCh, func(inCh <-chan interface{}) { err = fnc(ctx, inCh, produced) }) && err != nil {
+				r.reportError(nil, err)
+			}
+		}()
+		go func() {
+			defer close(outCh)
+			r.emit(produced, outCh)
+		}()
+		return outCh
+	})
+}
+
+// FromChan creates a producer sending the values of the given channel, until it is closed (see
+// Merge). Its input channel is ignored.
+func FromChan(ch <-chan interface{}, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if ch == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, "fromchan")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			r.emit(ch, outCh)
+		}()
+		return outCh
+	})
+}
+
+// produce starts a producer with its input channel bound to the stage context. The producer is
+// started again if it panics with the PanicRestart policy. It returns false if the producer can't
+// be started.
+func produce(r *stageRuntime, inCh <-chan interface{}, start func(inCh <-chan interface{})) bool {
+	inCh = bindChan(r.ctx, untraceChan(r.ctx, inCh))
+	for {
+		ok, policy := Protect(r.ctx, r.id, nil, func() { start(inCh) })
+		if ok {
+			return true
+		} else if policy != PanicRestart {
+			go flushChan(inCh)
+			return false
+		}
+	}
+}
+
 // C is a short alias for Consumer
-func C(fnc func(obj interface{}) interface{}) Stage { return Consumer(fnc) }
+func C(fnc func(obj interface{}) interface{}, opts ...StageOption) Stage {
+	return Consumer(fnc, opts...)
+}
 
 // Consumer is the main 'worker'; it consume the given object and return another one.
-func Consumer(fnc func(obj interface{}) interface{}) Stage {
-	return StageFnc(func(inCh <-chan interface{}) <-chan interface{} {
+func Consumer(fnc func(obj interface{}) interface{}, opts ...StageOption) Stage {
+	if fnc == nil {
+		return ConsumerE(nil, opts...)
+	}
+	return ConsumerE(func(obj interface{}) (interface{}, error) { return fnc(obj), nil }, opts...)
+}
+
+// CE is a short alias for ConsumerE
+func CE(fnc func(obj interface{}) (interface{}, error), opts ...StageOption) Stage {
+	return ConsumerE(fnc, opts...)
+}
+
+// ConsumerE is a Consumer that can fail. When an error is returned, nothing is sent to the next
+// stage and the error is reported to the pipeline with the consumed object (see Pipeline.Start).
+func ConsumerE(fnc func(obj interface{}) (interface{}, error), opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if fnc == nil || inCh == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, "consumer")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, span, open := r.recv(inCh)
+				if !open {
+					return
+				}
+
+				var out interface{}
+				var err error
+				ok, policy := r.process(span, in, func() { out, err = fnc(in) })
+				span.end(err)
+				switch {
+				case !ok && policy == PanicAbort:
+					go flushChan(inCh)
+					return
+				case !ok:
+					continue
+				case err != nil:
+					r.reportError(in, err)
+					continue
+				}
+				if !r.send(outCh, span.wrap(out)) {
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
+
+// FlatMap consumes the given object and returns zero, one or many objects, sent to the next stage in
+// the same order.
+func FlatMap(fnc func(obj interface{}) []interface{}, opts ...StageOption) Stage {
+	if fnc == nil {
+		return FlatMapEmit(nil, opts...)
+	}
+	return FlatMapEmit(func(obj interface{}, emit func(out interface{}) bool) error {
+		for _, out := range fnc(obj) {
+			if !emit(out) {
+				break
+			}
+		}
+		return nil
+	}, opts...)
+}
+
+// FlatMapEmit consumes the given object and sends zero, one or many objects to the next stage through
+// the emit callback. Emit blocks until the object is sent and returns false if the pipeline is
+// stopped; it must not be used after fnc returns. When an error is returned, it is reported to the
+// pipeline with the consumed object (see Pipeline.Start); the objects already emitted are kept.
+func FlatMapEmit(fnc func(obj interface{}, emit func(out interface{}) bool) error, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
 		if fnc == nil || inCh == nil {
 			return inCh
 		}
 
-		outCh := make(chan interface{}, BufferedChanSize)
+		r := cfg.start(ctx, "flatmap")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
 		go func() {
 			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, span, open := r.recv(inCh)
+				if !open {
+					return
+				}
+
+				stopped := false
+				emit := func(out interface{}) bool {
+					stopped = stopped || !r.send(outCh, span.wrap(out))
+					return !stopped
+				}
 
-			for in := range inCh {
-				outCh <- fnc(in)
+				var err error
+				ok, policy := r.process(span, in, func() { err = fnc(in, emit) })
+				span.end(err)
+				switch {
+				case stopped:
+					return
+				case !ok && policy == PanicAbort:
+					go flushChan(inCh)
+					return
+				case err != nil:
+					r.reportError(in, err)
+				}
 			}
 		}()
 		return outCh
@@ -1,8 +1,11 @@
 package pipeline_test
 
 import (
+	"context"
+	"errors"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -69,3 +72,260 @@ func TestProducer_NilFunc(t *testing.T) {
 
 	assert.Equal(t, (<-chan interface{})(in), out)
 }
+
+func TestConsumer_Cancel(t *testing.T) {
+	c := pipeline.C(func(obj interface{}) interface{} { return obj })
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := c.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	select {
+	case in <- 1: // the input channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must not be blocked")
+	}
+}
+
+func TestProducer_Cancel(t *testing.T) {
+	p := pipeline.P(func(in <-chan interface{}) <-chan interface{} {
+		out := make(chan interface{})
+		go func() {
+			defer close(out)
+			for range in {
+			}
+		}()
+		return out
+	})
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := p.(pipeline.ContextStage).RunContext(ctx, in)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestProducerContext_Cancel(t *testing.T) {
+	done := make(chan struct{})
+	p := pipeline.ProducerContext(func(ctx context.Context, _ <-chan interface{}) <-chan interface{} {
+		out := make(chan interface{})
+		go func() {
+			defer close(done)
+			defer close(out)
+			for {
+				select {
+				case out <- 1:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := p.(pipeline.ContextStage).RunContext(ctx, nil)
+
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("producer must be stopped")
+	}
+}
+
+func TestFromChan(t *testing.T) {
+	ch := make(chan interface{}, 2)
+	ch <- 1
+	ch <- 2
+	close(ch)
+
+	out := pipeline.Pipeline{
+		pipeline.FromChan(ch),
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+	}.Run(nil)
+
+	assert.Equal(t, 2, <-out)
+	assert.Equal(t, 4, <-out)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestConsumerE(t *testing.T) {
+	c := pipeline.CE(func(obj interface{}) (interface{}, error) {
+		if obj.(int) < 0 {
+			return nil, errors.New("negative number")
+		}
+		return obj.(int) * 2, nil
+	})
+
+	in := make(chan interface{})
+	out := c.Run(in)
+
+	in <- -1 // dropped
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 10, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestProducerE(t *testing.T) {
+	p := pipeline.ProducerE(func(_ context.Context, _ <-chan interface{}, out chan<- interface{}) error {
+		out <- 1
+		return errors.New("producer failure")
+	})
+
+	exec := pipeline.Pipeline{p}.Start(context.Background(), nil)
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.EqualError(t, exec.Wait(), "0.producer: producer failure")
+}
+
+func TestConsumer_BufferSize(t *testing.T) {
+	c := pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.BufferSize(4))
+
+	in := make(chan interface{})
+	out := c.Run(in)
+	defer close(in)
+
+	assert.Equal(t, 4, cap(out))
+}
+
+func TestConsumer_NegativeBufferSize(t *testing.T) {
+	c := pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.BufferSize(-1))
+
+	in := make(chan interface{})
+	out := c.Run(in)
+	defer close(in)
+
+	assert.Equal(t, 0, cap(out))
+}
+
+func TestFlatMap(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.FlatMap(func(obj interface{}) []interface{} {
+			outs := make([]interface{}, obj.(int))
+			for i := range outs {
+				outs[i] = obj
+			}
+			return outs
+		}),
+	}
+
+	in := make(chan interface{})
+	out := p.Run(in)
+	go func() {
+		for i := 0; i < 4; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	var values []interface{}
+	for value := range out {
+		values = append(values, value)
+	}
+	assert.Equal(t, []interface{}{1, 2, 2, 3, 3, 3}, values)
+}
+
+func TestFlatMapEmit(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.FlatMapEmit(func(obj interface{}, emit func(out interface{}) bool) error {
+			emit(obj)
+			if obj.(int)%2 == 1 {
+				return errOdd
+			}
+			emit(obj)
+			return nil
+		}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithErrorMode(pipeline.ContinueOnError))
+	go func() {
+		for i := 0; i < 4; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	var errs []interface{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range exec.Errors() {
+			errs = append(errs, err.Item)
+		}
+	}()
+
+	var values []interface{}
+	for value := range exec.Out() {
+		values = append(values, value)
+	}
+	<-done
+	assert.Equal(t, []interface{}{0, 0, 1, 2, 2, 3}, values)
+	assert.Equal(t, []interface{}{1, 3}, errs)
+}
+
+func TestFlatMapEmit_Backpressure(t *testing.T) {
+	emitted := make(chan bool)
+	f := pipeline.FlatMapEmit(func(obj interface{}, emit func(out interface{}) bool) error {
+		for i := 0; i < 3; i++ {
+			emitted <- emit(i)
+		}
+		return nil
+	}, pipeline.BufferSize(0))
+
+	in := make(chan interface{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.Pipeline{f}.RunContext(ctx, in)
+	in <- nil
+
+	select {
+	case <-emitted:
+		t.Errorf("emit must block until the output is consumed")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	assert.Equal(t, 0, <-out)
+	assert.True(t, <-emitted)
+	cancel()
+	assert.False(t, <-emitted)
+	assert.False(t, <-emitted)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestFlatMap_Parallelize(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(3, pipeline.LRFilter(
+			func(obj interface{}) bool { return obj.(int)%2 == 0 },
+			pipeline.Pipeline{pipeline.FlatMap(func(obj interface{}) []interface{} { return []interface{}{obj, obj} })},
+			pipeline.Pipeline{pipeline.FlatMap(func(obj interface{}) []interface{} { return nil })},
+		)),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+	go func() {
+		for i := 0; i < 6; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	var values []interface{}
+	for value := range exec.Out() {
+		values = append(values, value)
+	}
+	assert.NoError(t, exec.Wait())
+	assert.ElementsMatch(t, []interface{}{0, 0, 2, 2, 4, 4}, values)
+}
@@ -1,6 +1,9 @@
 package pipeline
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Predicate is a function that returns true or false depending on the given input.
 type Predicate func(interface{}) bool
@@ -8,30 +11,50 @@ type Predicate func(interface{}) bool
 // LRFilter (or Left/Right Filter) filters values depending on the given predicate. For each value
 // of the input channel, if the predicate returns true, the value is sent to the left pipeline,
 // otherwise, the value is sent to the right one.
-func LRFilter(predicate Predicate, left Pipeline, right Pipeline) Stage {
-	return StageFnc(func(in <-chan interface{}) <-chan interface{} {
+func LRFilter(predicate Predicate, left Pipeline, right Pipeline, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
 		if predicate == nil || (len(left) == 0 && len(right) == 0) || (hasNilStage(left) && hasNilStage(right)) {
 			return in
 		}
 
-		lchan := make(chan interface{}, (cap(in)/2)+1)
-		rchan := make(chan interface{}, (cap(in)/2)+1)
-		out := make(chan interface{}, cap(in))
+		r := cfg.start(ctx, "lrfilter")
+		lchan := make(chan interface{}, cfg.chanSize(ctx, (cap(in)/2)+1))
+		rchan := make(chan interface{}, cfg.chanSize(ctx, (cap(in)/2)+1))
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)))
 
 		wg := &sync.WaitGroup{}
 		wg.Add(2)
-		go runPipeline(left, lchan, out, wg)
-		go runPipeline(right, rchan, out, wg)
+		go runPipeline(r.scope("left"), r, left, wg, lchan, out)
+		go runPipeline(r.scope("right"), r, right, wg, rchan, out)
 
 		go func() {
 			defer close(lchan)
 			defer close(rchan)
+			defer flushOnCancel(ctx, in)
 
-			for value := range in {
-				if predicate(value) {
-					lchan <- value
-				} else {
-					rchan <- value
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					return
+				}
+
+				var isLeft bool
+				ok, policy := r.process(span, value, func() { isLeft = predicate(value) })
+				span.end(nil)
+				if !ok && policy == PanicAbort {
+					go flushChan(in)
+					return
+				} else if !ok {
+					continue
+				}
+
+				ch := rchan
+				if isLeft {
+					ch = lchan
+				}
+				if !r.dispatch(ch, span.wrap(value)) {
+					return
 				}
 			}
 		}()
@@ -40,11 +63,71 @@ func LRFilter(predicate Predicate, left Pipeline, right Pipeline) Stage {
 	})
 }
 
-func runPipeline(pipeline Pipeline, in <-chan interface{}, out chan<- interface{}, wg *sync.WaitGroup) {
+// runPipeline runs the given sub-pipeline of a stage with the given context and sends its outputs
+// as outputs of the stage.
+func runPipeline(ctx context.Context, r *stageRuntime, pipeline Pipeline, wg *sync.WaitGroup, in <-chan interface{}, out chan<- interface{}) {
 	defer wg.Done()
 
-	values := pipeline.Run(in)
-	for value := range values {
-		out <- value
+	values := runProtected(ctx, r.id, pipeline, in)
+	if values == nil {
+		return
 	}
+	r.forward(values, out)
+}
+
+// Filter sends to the next stage only the values for which the given predicate returns true; the
+// other values are dropped (see Dropped).
+func Filter(predicate Predicate, opts ...StageOption) Stage {
+	return filter("filter", predicate, true, opts)
+}
+
+// Reject drops the values for which the given predicate returns true (see Dropped) and sends the
+// other values to the next stage.
+func Reject(predicate Predicate, opts ...StageOption) Stage {
+	return filter("reject", predicate, false, opts)
+}
+
+// filter sends the values for which the predicate returns keep and drops the others.
+func filter(kind string, predicate Predicate, keep bool, opts []StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if predicate == nil || in == nil {
+			return in
+		}
+
+		r := cfg.start(ctx, kind)
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(out)
+			defer flushOnCancel(ctx, in)
+
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					return
+				}
+
+				var matched bool
+				ok, policy := r.process(span, value, func() { matched = predicate(value) })
+				span.end(nil)
+				switch {
+				case !ok && policy == PanicAbort:
+					go flushChan(in)
+					return
+				case !ok:
+					continue
+				case matched != keep:
+					r.drop()
+					if cfg.dropped != nil && !sendContext(ctx, cfg.dropped, value) {
+						return
+					}
+				default:
+					if !r.send(out, span.wrap(value)) {
+						return
+					}
+				}
+			}
+		}()
+		return out
+	})
 }
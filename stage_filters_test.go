@@ -1,8 +1,10 @@
 package pipeline_test
 
 import (
+	"context"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -84,3 +86,93 @@ func TestLRFilter_InvalidPipeline(t *testing.T) {
 
 	assert.Equal(t, (<-chan interface{})(in), out)
 }
+
+func TestLRFilter_Cancel(t *testing.T) {
+	lock := make(chan interface{})
+	defer close(lock)
+
+	f := pipeline.LRFilter(
+		func(i interface{}) bool { return i.(int) < 0 },
+		pipeline.Pipeline{
+			pipeline.C(func(obj interface{}) interface{} { return obj }),
+		},
+		pipeline.Pipeline{
+			pipeline.StageFnc(func(in <-chan interface{}) <-chan interface{} { return lock }),
+		},
+	)
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := f.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- -1
+	assert.Equal(t, -1, <-out)
+	in <- 1 // sent to the locked pipeline
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestFilter(t *testing.T) {
+	f := pipeline.Filter(func(i interface{}) bool { return i.(int)%2 == 0 })
+
+	in := make(chan interface{})
+	out := f.Run(in)
+	go func() {
+		for i := 0; i < 5; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	assert.Equal(t, 0, <-out)
+	assert.Equal(t, 2, <-out)
+	assert.Equal(t, 4, <-out)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestReject_Dropped(t *testing.T) {
+	dropped := make(chan interface{}, 5)
+	f := pipeline.Reject(func(i interface{}) bool { return i.(int)%2 == 0 }, pipeline.Dropped(dropped))
+
+	in := make(chan interface{})
+	out := f.Run(in)
+	go func() {
+		for i := 0; i < 5; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	assert.Equal(t, 1, <-out)
+	assert.Equal(t, 3, <-out)
+	assertClosed(t, out, 100*time.Millisecond)
+
+	close(dropped)
+	var values []interface{}
+	for value := range dropped {
+		values = append(values, value)
+	}
+	assert.Equal(t, []interface{}{0, 2, 4}, values)
+}
+
+func TestFilter_NilPredicate(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Filter(nil).Run(in)
+
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestFilter_Cancel(t *testing.T) {
+	dropped := make(chan interface{}) // never consumed
+	ctx, cancel := context.WithCancel(context.Background())
+
+	in := make(chan interface{})
+	out := pipeline.Pipeline{
+		pipeline.Filter(func(i interface{}) bool { return false }, pipeline.Dropped(dropped)),
+	}.RunContext(ctx, in)
+
+	in <- 0 // blocks the stage on the dropped channel
+	cancel()
+	assertClosed(t, out, 100*time.Millisecond)
+}
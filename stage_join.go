@@ -0,0 +1,233 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// JoinType defines which items are sent by a Join stage.
+type JoinType int
+
+const (
+	// InnerJoin sends only the matching items; the unmatched items are dropped (see Dropped).
+	InnerJoin JoinType = iota
+	// LeftJoin sends the matching items and the unmatched left items.
+	LeftJoin
+	// OuterJoin sends the matching items and all unmatched items.
+	OuterJoin
+)
+
+// JoinConfig defines how a Join stage matches the items of its sources.
+type JoinConfig struct {
+	LeftKey  func(item interface{}) interface{} // LeftKey returns the key of a left item
+	RightKey func(item interface{}) interface{} // RightKey returns the key of a right item
+	Window   time.Duration                      // Window is the maximum time between two matching items
+	Type     JoinType
+	// MaxBuffered is the maximum number of items of a key buffered for each source (unbounded if
+	// zero); the oldest ones are evicted first, like expired items.
+	MaxBuffered int
+}
+
+// Joined is a pair of items sent by a Join stage. The missing item of an unmatched item is nil.
+type Joined struct {
+	Left, Right interface{}
+}
+
+// Join is a producer matching the items of the left and right sources (like FromChan or a Pipeline)
+// which have the same key and are received within the configured window. Each pair of matching items
+// is sent as Joined as soon as the second one is received. An item is buffered until its window
+// expires: it is then sent alone depending on the join type if it hasn't been matched. The time is
+// given by the pipeline clock (see WithClock) and the buffered items are expired when both sources
+// are closed. The keys must be comparable and the sources are run like Merge.
+func Join(left, right Stage, config JoinConfig, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if left == nil || right == nil || config.LeftKey == nil || config.RightKey == nil || config.Window <= 0 {
+			return in
+		}
+
+		r := cfg.start(ctx, "join")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		chs := runSources(r, []Stage{left, right}, in)
+		go func() {
+			defer close(out)
+			defer func() {
+				for _, ch := range chs {
+					flushOnCancel(ctx, ch)
+				}
+			}()
+
+			j := &joiner{r: r, cfg: cfg, config: config, outCh: out, buffers: map[interface{}]*joinBuffer{}}
+			defer j.stopTimer()
+
+			for chs[joinLeft] != nil || chs[joinRight] != nil {
+				var ok bool
+				select {
+				case value, open := <-chs[joinLeft]:
+					ok = j.recv(joinLeft, value, open, chs)
+				case value, open := <-chs[joinRight]:
+					ok = j.recv(joinRight, value, open, chs)
+				case <-j.timeout:
+					ok = j.expire(func(entry *joinEntry) bool { return !j.r.clock.Now().Before(entry.expires) })
+				case <-ctx.Done():
+					return
+				}
+
+				if !ok {
+					for _, ch := range chs {
+						go flushChan(ch)
+					}
+					return
+				}
+			}
+			j.expire(func(*joinEntry) bool { return true })
+		}()
+		return out
+	})
+}
+
+const (
+	joinLeft  = 0
+	joinRight = 1
+)
+
+// joiner holds the buffered items of a Join stage.
+type joiner struct {
+	r      *stageRuntime
+	cfg    stageConfig
+	config JoinConfig
+	outCh  chan<- interface{}
+
+	buffers map[interface{}]*joinBuffer
+	queue   []*joinEntry // queue are the buffered items, sorted by expiration
+	timer   Timer        // timer fires when the first buffered item expires
+	timeout <-chan time.Time
+}
+
+// joinBuffer are the buffered items of a key, for each source.
+type joinBuffer [2][]*joinEntry
+
+type joinEntry struct {
+	key     interface{}
+	side    int
+	value   interface{}
+	expires time.Time
+	matched bool
+	removed bool // removed is true once the entry has been expired or evicted
+}
+
+// recv handles a value (or the closure) of the given source. It returns false if the stage must be
+// stopped.
+func (j *joiner) recv(side int, in interface{}, open bool, chs []<-chan interface{}) bool {
+	if !open {
+		chs[side] = nil
+		return true
+	}
+
+	value, span := j.r.received(in)
+	defer span.end(nil)
+
+	keyFnc := j.config.LeftKey
+	if side == joinRight {
+		keyFnc = j.config.RightKey
+	}
+
+	var key interface{}
+	var buffer *joinBuffer
+	ok, policy := j.r.process(span, value, func() {
+		key = keyFnc(value)
+		if buffer = j.buffers[key]; buffer == nil {
+			buffer = &joinBuffer{}
+			j.buffers[key] = buffer
+		}
+	})
+	if !ok {
+		return policy != PanicAbort
+	}
+
+	now := j.r.clock.Now()
+	entry := &joinEntry{key: key, side: side, value: value, expires: now.Add(j.config.Window)}
+	for _, other := range buffer[1-side] {
+		if !now.Before(other.expires) {
+			continue // expired, but not removed yet
+		}
+
+		other.matched, entry.matched = true, true
+		if !j.r.send(j.outCh, entry.joined(other)) {
+			return false
+		}
+	}
+
+	buffer[side] = append(buffer[side], entry)
+	j.queue = append(j.queue, entry)
+	if j.config.MaxBuffered > 0 && len(buffer[side]) > j.config.MaxBuffered && !j.remove(buffer[side][0]) {
+		return false
+	}
+
+	if j.timer == nil {
+		j.resetTimer()
+	}
+	return true
+}
+
+// joined returns the pair of the entry with the given entry of the other source.
+func (e *joinEntry) joined(other *joinEntry) Joined {
+	if e.side == joinLeft {
+		return Joined{Left: e.value, Right: other.value}
+	}
+	return Joined{Left: other.value, Right: e.value}
+}
+
+// expire removes the buffered items, in order, while expired returns true. It returns false if the
+// stage must be stopped.
+func (j *joiner) expire(expired func(*joinEntry) bool) bool {
+	j.stopTimer()
+	for len(j.queue) > 0 && (j.queue[0].removed || expired(j.queue[0])) {
+		entry := j.queue[0]
+		j.queue = j.queue[1:]
+		if !entry.removed && !j.remove(entry) {
+			return false
+		}
+	}
+	j.resetTimer()
+	return true
+}
+
+// remove removes the given entry, which must be the oldest of its key and source, and sends it if
+// it is unmatched, depending on the join type. It returns false if the stage must be stopped.
+func (j *joiner) remove(entry *joinEntry) bool {
+	entry.removed = true
+	buffer := j.buffers[entry.key]
+	buffer[entry.side] = buffer[entry.side][1:]
+	if len(buffer[joinLeft]) == 0 && len(buffer[joinRight]) == 0 {
+		delete(j.buffers, entry.key)
+	}
+
+	switch {
+	case entry.matched:
+		return true
+	case entry.side == joinLeft && j.config.Type != InnerJoin:
+		return j.r.send(j.outCh, Joined{Left: entry.value})
+	case entry.side == joinRight && j.config.Type == OuterJoin:
+		return j.r.send(j.outCh, Joined{Right: entry.value})
+	}
+
+	j.r.drop()
+	return j.cfg.dropped == nil || sendContext(j.r.ctx, j.cfg.dropped, entry.value)
+}
+
+// resetTimer starts the timer firing when the first buffered item expires.
+func (j *joiner) resetTimer() {
+	j.stopTimer()
+	if len(j.queue) > 0 {
+		j.timer = j.r.clock.NewTimer(j.queue[0].expires.Sub(j.r.clock.Now()))
+		j.timeout = j.timer.C()
+	}
+}
+
+func (j *joiner) stopTimer() {
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer, j.timeout = nil, nil
+	}
+}
@@ -0,0 +1,113 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+type order struct{ id, seq int }
+type payment struct{ order int }
+
+func joinOrders(joinType pipeline.JoinType, maxBuffered int) pipeline.JoinConfig {
+	return pipeline.JoinConfig{
+		LeftKey:     func(item interface{}) interface{} { return item.(order).id },
+		RightKey:    func(item interface{}) interface{} { return item.(payment).order },
+		Window:      10 * time.Second,
+		Type:        joinType,
+		MaxBuffered: maxBuffered,
+	}
+}
+
+func TestJoin(t *testing.T) {
+	for name, tc := range map[string]struct {
+		joinType  pipeline.JoinType
+		unmatched []interface{}
+		dropped   []interface{}
+	}{
+		"inner": {pipeline.InnerJoin, nil, []interface{}{order{id: 2}, payment{3}}},
+		"left":  {pipeline.LeftJoin, []interface{}{pipeline.Joined{Left: order{id: 2}}}, []interface{}{payment{3}}},
+		"outer": {pipeline.OuterJoin, []interface{}{pipeline.Joined{Left: order{id: 2}}, pipeline.Joined{Right: payment{3}}}, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			orders, payments := make(chan interface{}), make(chan interface{})
+			dropped := make(chan interface{}, 2)
+			p := pipeline.Pipeline{
+				pipeline.Join(pipeline.FromChan(orders), pipeline.FromChan(payments), joinOrders(tc.joinType, 0), pipeline.Dropped(dropped)),
+			}
+			exec := p.Start(context.Background(), nil, pipeline.WithClock(newFakeClock()))
+
+			orders <- order{id: 1}
+			payments <- payment{1}
+			assert.Equal(t, pipeline.Joined{Left: order{id: 1}, Right: payment{1}}, <-exec.Out())
+
+			orders <- order{id: 2}
+			payments <- payment{3}
+			close(orders)
+			close(payments) // the buffered items are expired
+
+			var unmatched []interface{}
+			for value := range exec.Out() {
+				unmatched = append(unmatched, value)
+			}
+			close(dropped)
+			var drops []interface{}
+			for value := range dropped {
+				drops = append(drops, value)
+			}
+
+			assert.ElementsMatch(t, tc.unmatched, unmatched)
+			assert.ElementsMatch(t, tc.dropped, drops)
+		})
+	}
+}
+
+func TestJoin_Window(t *testing.T) {
+	clock := newFakeClock()
+	orders, payments := make(chan interface{}), make(chan interface{})
+	dropped := make(chan interface{}, 1)
+	p := pipeline.Pipeline{
+		pipeline.Join(pipeline.FromChan(orders), pipeline.FromChan(payments), joinOrders(pipeline.LeftJoin, 0), pipeline.Dropped(dropped)),
+	}
+	exec := p.Start(context.Background(), nil, pipeline.WithClock(clock))
+
+	orders <- order{id: 1}
+	clock.WaitCreated(t, 1)
+	clock.Advance(10 * time.Second) // the window of the order expires
+	assert.Equal(t, pipeline.Joined{Left: order{id: 1}}, <-exec.Out())
+
+	payments <- payment{1} // too late
+	clock.WaitCreated(t, 2)
+	close(orders)
+	close(payments)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, payment{1}, <-dropped)
+}
+
+func TestJoin_MaxBuffered(t *testing.T) {
+	orders, payments := make(chan interface{}), make(chan interface{})
+	p := pipeline.Pipeline{
+		pipeline.Join(pipeline.FromChan(orders), pipeline.FromChan(payments), joinOrders(pipeline.OuterJoin, 1)),
+	}
+	exec := p.Start(context.Background(), nil, pipeline.WithClock(newFakeClock()))
+
+	orders <- order{id: 1, seq: 1}
+	orders <- order{id: 1, seq: 2} // evicts the first order of the key
+	assert.Equal(t, pipeline.Joined{Left: order{id: 1, seq: 1}}, <-exec.Out())
+
+	payments <- payment{1}
+	assert.Equal(t, pipeline.Joined{Left: order{id: 1, seq: 2}, Right: payment{1}}, <-exec.Out())
+	close(orders)
+	close(payments)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestJoin_NilKey(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Join(pipeline.FromChan(in), pipeline.FromChan(in), pipeline.JoinConfig{Window: time.Second}).Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
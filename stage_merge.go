@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+)
+
+// MergeStrategy defines how Merge selects the next value when several sources are ready.
+type MergeStrategy int
+
+const (
+	// MergeRoundRobin selects the ready sources in turn, so that each one gets a fair share.
+	MergeRoundRobin MergeStrategy = iota
+	// MergePriority selects the first ready source, in the given order.
+	MergePriority
+	// MergeRandom selects a random ready source.
+	MergeRandom
+)
+
+// Merge is a producer sending the values of all given sources (like FromChan or Producer), selected
+// with the given strategy. Its output channel is closed once all sources are closed.
+// The sources are run with an input channel closed when the input channel of the stage is closed;
+// the values received by the stage are dropped.
+func Merge(strategy MergeStrategy, sources ...Stage) Stage {
+	return MergeWithOptions(nil, strategy, sources...)
+}
+
+// MergeWithOptions is a Merge configured with the given options.
+func MergeWithOptions(opts []StageOption, strategy MergeStrategy, sources ...Stage) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if len(sources) == 0 || hasNilStage(sources) {
+			return in
+		}
+
+		r := cfg.start(ctx, "merge")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		m := &merger{r: r, strategy: strategy, chs: runSources(r, sources, in)}
+		go func() {
+			defer close(out)
+			defer m.flushOnCancel()
+
+			for {
+				value, open := m.next()
+				if !open || !r.send(out, value) {
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
+
+// runSources runs the given sources of a stage and returns their output channels. The sources are
+// run with an input channel closed when the given input channel is closed (or nil); its values are
+// dropped.
+func runSources(r *stageRuntime, sources []Stage, in <-chan interface{}) []<-chan interface{} {
+	sourcesIn := make(chan interface{})
+	if in == nil {
+		close(sourcesIn) // a nil channel never sends anything, like a closed one
+	} else {
+		go func() {
+			defer close(sourcesIn)
+			defer flushOnCancel(r.ctx, in)
+
+			for {
+				if _, open := recvContext(r.ctx, in); !open {
+					return
+				}
+				r.drop()
+			}
+		}()
+	}
+
+	chs := make([]<-chan interface{}, len(sources))
+	for i, source := range sources {
+		chs[i] = runProtected(withStageIndex(r.scope(""), i), r.id, source, sourcesIn)
+	}
+	return chs
+}
+
+// merger selects the values of the sources of a Merge stage.
+type merger struct {
+	r        *stageRuntime
+	strategy MergeStrategy
+	chs      []<-chan interface{} // chs are the output channels of the sources, nil once closed
+	last     int                  // last is the index of the last selected source
+}
+
+// next returns the next selected value. It returns false if all sources are closed or if the
+// pipeline is stopped.
+func (m *merger) next() (interface{}, bool) {
+	for {
+		_, value, open, ok := m.recv()
+		if !ok {
+			return nil, false
+		} else if open {
+			return value, true
+		}
+	}
+}
+
+// recv returns the next selected value with the index of its source, or the index of the next
+// closed source (open is then false). It returns false if all sources are closed or if the pipeline
+// is stopped.
+func (m *merger) recv() (i int, value interface{}, open bool, ok bool) {
+	i, value, open, selected := m.poll()
+	if !selected {
+		if i, value, open, selected = m.wait(); !selected {
+			return 0, nil, false, false
+		}
+	}
+
+	if !open {
+		m.chs[i] = nil
+	} else {
+		m.last = i
+	}
+	return i, value, open, true
+}
+
+// poll tries to receive a value from the ready sources, in the order defined by the strategy,
+// without blocking. With MergeRandom, the value is selected by wait instead.
+func (m *merger) poll() (int, interface{}, bool, bool) {
+	n := len(m.chs)
+	first := 0
+	switch m.strategy {
+	case MergeRoundRobin:
+		first = m.last + 1
+	case MergeRandom:
+		return 0, nil, false, false
+	}
+
+	for k := 0; k < n; k++ {
+		i := (first + k) % n
+		if m.chs[i] == nil {
+			continue
+		}
+
+		select {
+		case value, open := <-m.chs[i]:
+			return i, value, open, true
+		default:
+		}
+	}
+	return 0, nil, false, false
+}
+
+// wait waits for a value of any source, selected randomly if several are ready. It returns false if
+// all sources are closed or if the pipeline is stopped.
+func (m *merger) wait() (int, interface{}, bool, bool) {
+	cases := make([]reflect.SelectCase, 0, len(m.chs)+1)
+	indexes := make([]int, 0, len(m.chs))
+	for i, ch := range m.chs {
+		if ch != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return 0, nil, false, false
+	}
+
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.r.ctx.Done())})
+	chosen, value, open := reflect.Select(cases)
+	if chosen == len(indexes) {
+		return 0, nil, false, false
+	}
+	if !open {
+		return indexes[chosen], nil, false, true
+	}
+	return indexes[chosen], value.Interface(), true, true
+}
+
+func (m *merger) flushOnCancel() {
+	for _, ch := range m.chs {
+		flushOnCancel(m.r.ctx, ch)
+	}
+}
@@ -0,0 +1,156 @@
+package pipeline_test
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// filledChan returns a closed channel containing the given values.
+func filledChan(values ...interface{}) <-chan interface{} {
+	ch := make(chan interface{}, len(values))
+	for _, value := range values {
+		ch <- value
+	}
+	close(ch)
+	return ch
+}
+
+// readyMerge runs a Merge stage with an unbuffered output and waits until all sources are ready.
+func readyMerge(strategy pipeline.MergeStrategy, sources ...pipeline.Stage) <-chan interface{} {
+	out := pipeline.MergeWithOptions([]pipeline.StageOption{pipeline.BufferSize(0)}, strategy, sources...).Run(nil)
+	time.Sleep(50 * time.Millisecond)
+	return out
+}
+
+func TestMerge_RoundRobin(t *testing.T) {
+	out := readyMerge(pipeline.MergeRoundRobin,
+		pipeline.FromChan(filledChan("a1", "a2", "a3")),
+		pipeline.FromChan(filledChan("b1", "b2", "b3")),
+	)
+
+	var values []string
+	for value := range out {
+		values = append(values, value.(string))
+	}
+	if assert.Len(t, values, 6) {
+		for i := 1; i < len(values); i++ {
+			assert.NotEqual(t, values[i-1][0], values[i][0], "sources must alternate: %v", values)
+		}
+	}
+}
+
+func TestMerge_Priority(t *testing.T) {
+	out := readyMerge(pipeline.MergePriority,
+		pipeline.FromChan(filledChan("a1", "a2", "a3")),
+		pipeline.FromChan(filledChan("b1", "b2", "b3")),
+	)
+
+	first := <-out // selected before the sources were ready
+	expected := []interface{}{"a1", "a2", "a3", "b1", "b2", "b3"}
+	for i, value := range expected {
+		if value == first {
+			expected = append(expected[:i], expected[i+1:]...)
+			break
+		}
+	}
+
+	var values []interface{}
+	for value := range out {
+		values = append(values, value)
+	}
+	assert.Equal(t, expected, values)
+}
+
+func TestMerge_Random(t *testing.T) {
+	out := pipeline.Merge(pipeline.MergeRandom,
+		pipeline.FromChan(filledChan(1, 2, 3)),
+		pipeline.P(func(in <-chan interface{}) <-chan interface{} { return filledChan(4, 5) }),
+		pipeline.Pipeline{
+			pipeline.FromChan(filledChan(6)),
+			pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 10 }),
+		},
+	).Run(nil)
+
+	var values []interface{}
+	for value := range out {
+		values = append(values, value)
+	}
+	assert.ElementsMatch(t, []interface{}{1, 2, 3, 4, 5, 60}, values)
+}
+
+func TestMerge_WaitAllSources(t *testing.T) {
+	pending := make(chan interface{})
+	out := pipeline.Merge(pipeline.MergeRoundRobin,
+		pipeline.FromChan(filledChan(1)),
+		pipeline.FromChan(pending),
+	).Run(nil)
+
+	assert.Equal(t, 1, <-out)
+	select {
+	case <-out:
+		t.Fatal("output channel must not be closed while a source is open")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pending <- 2
+	assert.Equal(t, 2, <-out)
+	close(pending)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestMerge_NoSource(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Merge(pipeline.MergeRoundRobin).Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestMerge_NilInput(t *testing.T) {
+	key := func(item interface{}) interface{} { return item }
+	tcases := map[string]func() pipeline.Stage{
+		"merge": func() pipeline.Stage {
+			return pipeline.Merge(pipeline.MergeRoundRobin, pipeline.FromChan(filledChan(1)), pipeline.FromChan(filledChan(2)))
+		},
+		"zip": func() pipeline.Stage {
+			return pipeline.Zip(pipeline.FromChan(filledChan(1)), pipeline.FromChan(filledChan(2)))
+		},
+		"combinelatest": func() pipeline.Stage {
+			return pipeline.CombineLatest(pipeline.FromChan(filledChan(1)), pipeline.FromChan(filledChan(2)))
+		},
+		"join": func() pipeline.Stage {
+			return pipeline.Join(pipeline.FromChan(filledChan(1)), pipeline.FromChan(filledChan(1)),
+				pipeline.JoinConfig{LeftKey: key, RightKey: key, Window: time.Minute})
+		},
+	}
+
+	for name, stage := range tcases {
+		t.Run(name, func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+			out := stage().Run(nil)
+
+			assertClosed(t, out, 100*time.Millisecond)
+			for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-goroutines)
+				}
+			}
+		})
+	}
+}
+
+func TestMerge_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	source := make(chan interface{})
+	out := pipeline.Merge(pipeline.MergePriority, pipeline.FromChan(source)).(pipeline.ContextStage).RunContext(ctx, nil)
+
+	source <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
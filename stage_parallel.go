@@ -1,47 +1,198 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"sync"
 )
 
+// ReorderPolicy defines the behaviour of ParallelizeOrdered when its reorder buffer is full.
+type ReorderPolicy int
+
+const (
+	// ReorderBlock blocks the input until the head-of-line item is processed.
+	ReorderBlock ReorderPolicy = iota
+	// ReorderFail stops the pipeline with ErrReorderBufferFull, whatever its error mode (see
+	// Execution.Wait). When the stage is not run through Pipeline.Start, nothing could report this
+	// error: the stage blocks like with ReorderBlock instead.
+	ReorderFail
+)
+
+// ErrReorderBufferFull stops the pipeline when the reorder buffer of ParallelizeOrdered is full and
+// the ReorderFail policy is used.
+var ErrReorderBufferFull = errors.New("reorder buffer is full")
+
 // Parallelize runs n times the given stage and merge theirs outputs in one channel.
-func Parallelize(n int, stage Stage) Stage {
-	return StageFnc(func(in <-chan interface{}) <-chan interface{} {
+func Parallelize(n int, stage Stage, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
 		if n == 0 || stage == nil || in == nil {
 			return in
 		}
 
-		out := make(chan interface{}, cap(in)*n) // We allow each stage to have a full size channel
+		r := cfg.start(ctx, "parallelize")
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)*n)) // We allow each stage to have a full size channel
+		in = r.observeChan(in)
 
 		wg := &sync.WaitGroup{}
 		wg.Add(n)
 		for i := 0; i < n; i++ {
-			go innerStage(stage, wg, in, out)
+			go innerStage(r.scope(""), r, stage, wg, in, out)
 		}
 		go func() { wg.Wait(); close(out) }() // Close out only when all goroutine are stopped
 		return out
 	})
 }
 
+// ParallelizeOrdered calls the given function like ConsumerE on n workers in parallel, but sends
+// theirs outputs in the input order. Each input is tagged with a sequence number and the outputs are
+// re-sequenced through a reorder buffer holding at most size items; the given policy defines what
+// happens when this buffer is full. An input dropped because of an error or a panic has no output
+// and doesn't hold back the next ones.
+func ParallelizeOrdered(n int, fnc func(obj interface{}) (interface{}, error), size int, policy ReorderPolicy, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if n <= 0 || fnc == nil || in == nil {
+			return in
+		}
+		bufferSize := size
+		if bufferSize < 1 {
+			bufferSize = n
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		r := cfg.start(ctx, "parallelize")
+		dispatched := make(chan interface{})
+		results := make(chan interface{}, n)
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)*n)) // We allow each stage to have a full size channel
+
+		var slots chan struct{}
+		if policy == ReorderBlock || executionFrom(ctx) == nil {
+			slots = make(chan struct{}, bufferSize)
+		}
+
+		wg := &sync.WaitGroup{}
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go orderedWorker(r, fnc, wg, dispatched, results)
+		}
+		go func() { wg.Wait(); close(results) }() // Close results only when all goroutine are stopped
+
+		go sequenceChan(r, in, dispatched, slots)
+		go func() {
+			defer cancel()
+			defer close(out)
+			defer flushOnCancel(ctx, results)
+
+			reorderChan(r, results, out, bufferSize, slots)
+		}()
+		return out
+	})
+}
+
+// PartitionBy runs n times the given stage like Parallelize, but sends each item to the stage
+// selected by the hash of its key. All items with the same key are then processed by the same stage,
+// in the input order, while the items of different keys are processed in parallel. When one of the
+// stages is blocked, this stage is blocked.
+func PartitionBy(n int, key func(item interface{}) interface{}, stage Stage, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if n == 0 || key == nil || stage == nil || in == nil {
+			return in
+		}
+
+		r := cfg.start(ctx, "partition")
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)*n)) // We allow each stage to have a full size channel
+		chs := make([]chan interface{}, n)
+
+		wg := &sync.WaitGroup{}
+		wg.Add(n)
+		for i := range chs {
+			chs[i] = make(chan interface{}, cfg.chanSize(ctx, cap(in)))
+			go innerStage(withStageIndex(r.scope(""), i), r, stage, wg, chs[i], out)
+		}
+		go partitionChan(r, key, in, chs)
+		go func() { wg.Wait(); close(out) }()
+		return out
+	})
+}
+
+// partitionChan sends all values of the input channel to the channel selected by the hash of their
+// key.
+func partitionChan(r *stageRuntime, key func(item interface{}) interface{}, in <-chan interface{}, chs []chan interface{}) {
+	defer func() {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}()
+	defer flushOnCancel(r.ctx, in)
+
+	for {
+		value, span, open := r.recv(in)
+		if !open {
+			return
+		}
+
+		var k interface{}
+		ok, policy := r.process(span, value, func() { k = key(value) })
+		span.end(nil)
+		switch {
+		case !ok && policy == PanicAbort:
+			go flushChan(in)
+			return
+		case !ok:
+			continue
+		}
+
+		if !r.dispatch(chs[partitionOf(k, len(chs))], span.wrap(value)) {
+			return
+		}
+	}
+}
+
+// partitionOf returns the partition of the given key, between 0 and n-1.
+func partitionOf(key interface{}, n int) int { return int(hashKey(key) % uint64(n)) }
+
+// hashKey returns the hash of the given key.
+func hashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	switch k := key.(type) {
+	case string:
+		_, _ = h.Write([]byte(k))
+	case []byte:
+		_, _ = h.Write(k)
+	default:
+		_, _ = fmt.Fprintf(h, "%T:%v", key, key)
+	}
+	return h.Sum64()
+}
+
 // Fork runs all given stage in parallel by duplicating all value received to all stages. When one
 // of the given stages is blocked, this stage is blocked. Use Mirror to block only if the
 // first stage are blocked.
-func Fork(stages ...Stage) Stage {
-	return StageFnc(func(in <-chan interface{}) <-chan interface{} {
+func Fork(stages ...Stage) Stage { return ForkWithOptions(nil, stages...) }
+
+// ForkWithOptions is a Fork configured with the given options.
+func ForkWithOptions(opts []StageOption, stages ...Stage) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
 		if len(stages) == 0 || hasNilStage(stages) || in == nil {
 			return in
 		}
 
-		out := make(chan interface{}, cap(in)*len(stages)) // We allow each stage to have a full size channel
+		r := cfg.start(ctx, "fork")
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)*len(stages))) // We allow each stage to have a full size channel
 		chs := make([]chan interface{}, len(stages))
 
 		wg := &sync.WaitGroup{}
 		wg.Add(len(stages))
 		for i, stage := range stages {
-			chs[i] = make(chan interface{}, cap(in))
-			go innerStage(stage, wg, chs[i], out)
+			chs[i] = make(chan interface{}, cfg.chanSize(ctx, cap(in)))
+			go innerStage(withStageIndex(r.scope(""), i), r, stage, wg, chs[i], out)
 		}
-		go multiplexChan(in, chs...)
+		go r.multiplex(in, chs...)
 		go func() { wg.Wait(); close(out) }()
 		return out
 	})
@@ -50,48 +201,175 @@ func Fork(stages ...Stage) Stage {
 // Mirror runs all given stage in parallel by duplicating all value received to all stages. When the main stage is
 // blocked, this stage is blocked. When one of the given mirrors is blocked, the next value is dropped. Use Fork to
 // block the stage when one of the given stages is blocked.
-func Mirror(main Stage, mirrors ...Stage) Stage {
-	return StageFnc(func(in <-chan interface{}) <-chan interface{} {
+func Mirror(main Stage, mirrors ...Stage) Stage { return MirrorWithOptions(nil, main, mirrors...) }
+
+// MirrorWithOptions is a Mirror configured with the given options.
+func MirrorWithOptions(opts []StageOption, main Stage, mirrors ...Stage) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
 		if main == nil || len(mirrors) == 0 || hasNilStage(mirrors) || in == nil {
 			return in
 		}
 
-		mainCh := make(chan interface{}, cap(in))
-		mirrorsCh := make(chan interface{}, cap(in)*(len(mirrors)+1)) // Input channel for multiplexed mirrors
-		out := make(chan interface{}, cap(in)*(len(mirrors)+1))       // We allow each stage to have a full size channel
+		r := cfg.start(ctx, "mirror")
+		mainCh := make(chan interface{}, cfg.chanSize(ctx, cap(in)))
+		mirrorsCh := make(chan interface{}, cfg.chanSize(ctx, cap(in)*(len(mirrors)+1))) // Input channel for multiplexed mirrors
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)*(len(mirrors)+1)))       // We allow each stage to have a full size channel
 		chs := make([]chan interface{}, len(mirrors))
 
 		wg := &sync.WaitGroup{}
 		wg.Add(len(mirrors) + 1)
-		go innerStage(main, wg, mainCh, out)
+		go innerStage(withStageIndex(r.scope(""), 0), r, main, wg, mainCh, out)
 		for i, stage := range mirrors {
-			chs[i] = make(chan interface{}, cap(in))
-			go innerStage(stage, wg, chs[i], out)
+			chs[i] = make(chan interface{}, cfg.chanSize(ctx, cap(in)))
+			go innerStage(withStageIndex(r.scope(""), i+1), r, stage, wg, chs[i], out)
 		}
 
 		go func() {
-			for in := range in {
-				mainCh <- in
-				mirrorsCh <- in
+			defer flushOnCancel(ctx, in)
+
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					break
+				}
+
+				span.end(nil)
+				if !r.dispatch(mainCh, span.wrap(value)) || !r.dispatch(mirrorsCh, span.wrap(value)) {
+					break
+				}
 			}
 
 			close(mainCh)
 			close(mirrorsCh)
 		}()
 
-		go multiplexChanNoLock(mirrorsCh, chs...)
+		go r.multiplexNoLock(mirrorsCh, chs...)
 		go func() { wg.Wait(); close(out) }()
 		return out
 	})
 }
 
-func innerStage(stage Stage, wg *sync.WaitGroup, in <-chan interface{}, out chan<- interface{}) {
+// innerStage runs the given sub-stage of a stage with the given context and sends its outputs as
+// outputs of the stage.
+func innerStage(ctx context.Context, r *stageRuntime, stage Stage, wg *sync.WaitGroup, in <-chan interface{}, out chan<- interface{}) {
 	defer wg.Done()
-	for ch := range stage.Run(in) {
-		out <- ch
+	r.worker(1)
+	defer r.worker(-1)
+
+	values := runProtected(ctx, r.id, stage, in)
+	if values == nil {
+		return
 	}
+	r.forward(values, out)
 
 	// avoid to block inCh if stage.Run close its output channel unexpectedly but allows to
 	// close global output channel
 	go flushChan(in)
 }
+
+// sequenced is a value tagged with its position in the input channel, with its output once
+// processed.
+type sequenced struct {
+	seq    uint64
+	value  interface{}
+	span   *itemSpan
+	output interface{}
+	sent   bool // sent is false if the value has been dropped
+}
+
+// sequenceChan tags all values of the input channel with their sequence number. If slots is not
+// nil, a slot must be acquired before sending each value.
+func sequenceChan(r *stageRuntime, in <-chan interface{}, out chan<- interface{}, slots chan<- struct{}) {
+	defer close(out)
+	defer flushOnCancel(r.ctx, in)
+
+	for seq := uint64(0); ; seq++ {
+		value, span, open := r.recv(in)
+		if !open {
+			return
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-r.ctx.Done():
+				span.end(nil)
+				return
+			}
+		}
+		if !sendContext(r.ctx, out, sequenced{seq: seq, value: value, span: span}) {
+			span.end(nil)
+			return
+		}
+	}
+}
+
+// reorderChan sends the outputs of the sequenced values to the output channel in the sequence order.
+// If slots is not nil, a slot is released each time a value is sent.
+func reorderChan(r *stageRuntime, in <-chan interface{}, out chan<- interface{}, size int, slots <-chan struct{}) {
+	pending := map[uint64]sequenced{}
+	next := uint64(0)
+
+	for {
+		value, open := recvContext(r.ctx, in)
+		if !open {
+			return
+		}
+
+		item := value.(sequenced)
+		if item.seq != next && len(pending) >= size {
+			r.fail(item.value, ErrReorderBufferFull)
+			return
+		}
+
+		pending[item.seq] = item
+		for item, exists := pending[next]; exists; item, exists = pending[next] {
+			if item.sent && !r.send(out, item.output) {
+				return
+			}
+
+			delete(pending, next)
+			next++
+			if slots != nil {
+				<-slots
+			}
+		}
+	}
+}
+
+// orderedWorker calls fnc for each sequenced value and sends it back with its output. A new value is
+// received only when the previous one has been processed, so that a busy worker never holds values
+// that other workers could process.
+func orderedWorker(r *stageRuntime, fnc func(obj interface{}) (interface{}, error), wg *sync.WaitGroup, in <-chan interface{}, out chan<- interface{}) {
+	defer wg.Done()
+	r.worker(1)
+	defer r.worker(-1)
+	defer flushOnCancel(r.ctx, in)
+
+	for {
+		value, open := recvContext(r.ctx, in)
+		if !open {
+			return
+		}
+
+		item := value.(sequenced)
+		var err error
+		ok, policy := r.process(item.span, item.value, func() { item.output, err = fnc(item.value) })
+		item.span.end(err)
+		switch {
+		case !ok && policy == PanicAbort:
+			go flushChan(in)
+			return
+		case !ok:
+		case err != nil:
+			r.reportError(item.value, err)
+		default:
+			item.output, item.sent = item.span.wrap(item.output), true
+		}
+
+		if !sendContext(r.ctx, out, item) {
+			return
+		}
+	}
+}
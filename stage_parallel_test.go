@@ -1,6 +1,8 @@
 package pipeline_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -245,3 +247,325 @@ func TestMirror_AllBlocked(t *testing.T) {
 		t.Errorf("input channel must not be blocked")
 	}
 }
+
+func TestParallelize_Cancel(t *testing.T) {
+	p := pipeline.Parallelize(
+		10,
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+	)
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := p.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	select {
+	case in <- 1: // the input channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must not be blocked")
+	}
+}
+
+func TestFork_Cancel(t *testing.T) {
+	lock := make(chan interface{})
+	defer close(lock)
+
+	f := pipeline.Fork(
+		pipeline.C(func(obj interface{}) interface{} { <-lock; return obj }),
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+	)
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := f.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	in <- 1 // lock the fork stage
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestMirror_Cancel(t *testing.T) {
+	lock := make(chan interface{})
+	defer close(lock)
+
+	f := pipeline.Mirror(
+		pipeline.C(func(obj interface{}) interface{} { <-lock; return obj }),
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+	)
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := f.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	in <- 1 // lock the mirror stage
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestParallelizeOrdered(t *testing.T) {
+	p := pipeline.ParallelizeOrdered(
+		10,
+		func(obj interface{}) (interface{}, error) {
+			time.Sleep(time.Duration(10-obj.(int)) * 10 * time.Millisecond)
+			return obj, nil
+		},
+		10,
+		pipeline.ReorderBlock,
+	)
+
+	in := make(chan interface{})
+	start := time.Now()
+	out := p.Run(in)
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, i, <-out)
+	}
+	_, open := <-out
+	assert.False(t, open)
+	assert.WithinDuration(t, time.Now(), start.Add(100*time.Millisecond), 50*time.Millisecond)
+}
+
+func TestParallelizeOrdered_Block(t *testing.T) {
+	lock := make(chan interface{})
+	p := pipeline.ParallelizeOrdered(
+		4,
+		func(obj interface{}) (interface{}, error) {
+			if obj.(int) == 0 {
+				<-lock
+			}
+			return obj, nil
+		},
+		2,
+		pipeline.ReorderBlock,
+	)
+
+	in := make(chan interface{})
+	out := p.Run(in)
+
+	in <- 0 // lock the head-of-line
+	in <- 1
+	in <- 2 // wait for a free slot in the reorder buffer
+	select {
+	case in <- 3:
+		t.Errorf("input channel must be blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(lock)
+	assert.Equal(t, 0, <-out)
+	assert.Equal(t, 1, <-out)
+	assert.Equal(t, 2, <-out)
+	close(in)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestParallelizeOrdered_Fail(t *testing.T) {
+	for name, mode := range map[string]pipeline.ErrorMode{"fail fast": pipeline.FailFast, "continue on error": pipeline.ContinueOnError} {
+		t.Run(name, func(t *testing.T) {
+			lock := make(chan interface{})
+			defer close(lock)
+
+			p := pipeline.Pipeline{
+				pipeline.ParallelizeOrdered(
+					4,
+					func(obj interface{}) (interface{}, error) {
+						if obj.(int) == 0 {
+							<-lock
+						}
+						return obj, nil
+					},
+					2,
+					pipeline.ReorderFail,
+				),
+			}
+
+			in := make(chan interface{})
+			exec := p.Start(context.Background(), in, pipeline.WithErrorMode(mode))
+			go func() {
+				for range exec.Errors() {
+					t.Errorf("the reorder error must stop the pipeline")
+				}
+			}()
+
+			for i := 0; i < 4; i++ {
+				in <- i
+			}
+
+			assertClosed(t, exec.Out(), 100*time.Millisecond)
+			err := exec.Wait()
+			assert.True(t, errors.Is(err, pipeline.ErrReorderBufferFull), "unexpected error %v", err)
+		})
+	}
+}
+
+func TestParallelizeOrdered_FailWithoutExecution(t *testing.T) {
+	p := pipeline.ParallelizeOrdered(2, func(obj interface{}) (interface{}, error) { return obj, nil }, 1, pipeline.ReorderFail)
+
+	in := make(chan interface{}, 10)
+	for i := 0; i < 10; i++ {
+		in <- i
+	}
+	close(in)
+
+	values, err := pipeline.Collect(context.Background(), p.Run(in))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, values)
+}
+
+func TestParallelizeOrdered_DroppedItems(t *testing.T) {
+	for name, tc := range map[string]struct {
+		fnc  func(obj interface{}) (interface{}, error)
+		opts []pipeline.RunOption
+	}{
+		"error": {
+			fnc: func(obj interface{}) (interface{}, error) {
+				if obj.(int) == 1 {
+					return nil, errOdd
+				}
+				return obj, nil
+			},
+			opts: []pipeline.RunOption{pipeline.WithErrorMode(pipeline.ContinueOnError)},
+		},
+		"panic": {
+			fnc: func(obj interface{}) (interface{}, error) {
+				if obj.(int) == 1 {
+					panic("unexpected value")
+				}
+				return obj, nil
+			},
+			opts: []pipeline.RunOption{pipeline.WithPanicPolicy(pipeline.PanicDrop)},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := pipeline.Pipeline{pipeline.ParallelizeOrdered(2, tc.fnc, 4, pipeline.ReorderBlock)}
+			exec := p.Start(context.Background(), filledChan(0, 1, 2, 3, 4), tc.opts...)
+
+			values, err := pipeline.Collect(context.Background(), exec.Out())
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{0, 2, 3, 4}, values)
+		})
+	}
+}
+
+func TestPartitionBy(t *testing.T) {
+	type update struct{ customer, seq int }
+
+	p := pipeline.PartitionBy(
+		4,
+		func(item interface{}) interface{} { return item.(update).customer },
+		pipeline.C(func(obj interface{}) interface{} {
+			time.Sleep(time.Duration(5-obj.(update).seq) * 10 * time.Millisecond) // the first updates are the slowest
+			return obj
+		}),
+	)
+
+	in := make(chan interface{})
+	out := p.Run(in)
+
+	go func() {
+		for seq := 0; seq < 5; seq++ {
+			for customer := 0; customer < 3; customer++ {
+				in <- update{customer: customer, seq: seq}
+			}
+		}
+		close(in)
+	}()
+
+	next := map[int]int{}
+	for value := range out {
+		item := value.(update)
+		assert.Equal(t, next[item.customer], item.seq)
+		next[item.customer]++
+	}
+	assert.Equal(t, map[int]int{0: 5, 1: 5, 2: 5}, next)
+}
+
+func TestPartitionBy_Zero(t *testing.T) {
+	p := pipeline.PartitionBy(0, func(item interface{}) interface{} { return item }, pipeline.C(func(obj interface{}) interface{} { return obj }))
+
+	in := make(chan interface{})
+	out := p.Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestPartitionBy_NilKey(t *testing.T) {
+	p := pipeline.PartitionBy(10, nil, pipeline.C(func(obj interface{}) interface{} { return obj }))
+
+	in := make(chan interface{})
+	out := p.Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestPartitionBy_Cancel(t *testing.T) {
+	p := pipeline.PartitionBy(4, func(item interface{}) interface{} { return item }, pipeline.C(func(obj interface{}) interface{} { return obj }))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	in := make(chan interface{})
+	out := p.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	select {
+	case in <- 1: // the input channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must not be blocked")
+	}
+}
+
+func TestForkWithOptions(t *testing.T) {
+	f := pipeline.ForkWithOptions(
+		[]pipeline.StageOption{pipeline.BufferSize(4)},
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+	)
+
+	in := make(chan interface{})
+	out := f.Run(in)
+
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 4, cap(out))
+	assert.Equal(t, 10, <-out)
+	assert.Equal(t, 10, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestMirrorWithOptions(t *testing.T) {
+	f := pipeline.MirrorWithOptions(
+		[]pipeline.StageOption{pipeline.BufferSize(4)},
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }),
+	)
+
+	in := make(chan interface{})
+	out := f.Run(in)
+
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 4, cap(out))
+	assert.Equal(t, 10, <-out)
+	assert.Equal(t, 10, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
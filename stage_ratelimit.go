@@ -0,0 +1,260 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"time"
+)
+
+// RateLimitPolicy defines the behaviour of RateLimit when an item exceeds the limit.
+type RateLimitPolicy int
+
+const (
+	// RateLimitWait delays the item until it is allowed by the limit.
+	RateLimitWait RateLimitPolicy = iota
+	// RateLimitDrop drops the item (see Dropped).
+	RateLimitDrop
+)
+
+// RateLimitConfig defines the limit of a RateLimit stage.
+type RateLimitConfig struct {
+	Rate   float64                            // Rate is the number of items allowed per second
+	Burst  int                                // Burst is the number of items allowed at once (at least 1)
+	Key    func(item interface{}) interface{} // Key returns the key of an item; each key has its own limit (optional)
+	Policy RateLimitPolicy
+}
+
+// RateLimit limits the rate of the items sent to the next stage, using a token bucket refilled at
+// the configured rate. The items exceeding the limit are delayed or dropped, depending on the
+// configured policy. The time is given by the pipeline clock (see WithClock).
+// With RateLimitWait, a delayed item only holds back the next items of its key: the items of the
+// other keys are sent as soon as they are allowed. The stage holds back at most as many items as the
+// capacity of its output channel; it stops reading its input channel beyond.
+func RateLimit(config RateLimitConfig, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if config.Rate <= 0 || in == nil {
+			return in
+		}
+
+		r := cfg.start(ctx, "ratelimit")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		l := &rateLimitStage{
+			r:       r,
+			cfg:     cfg,
+			config:  config,
+			limiter: newRateLimiter(config, r.clock),
+			outCh:   out,
+		}
+		go func() {
+			defer close(out)
+			defer flushOnCancel(ctx, in)
+			defer l.stopTimer()
+
+			maxDelayed := cap(out)
+			if maxDelayed < 1 {
+				maxDelayed = 1
+			}
+			for inCh := in; ; {
+				if !l.release() || (inCh == nil && len(l.delayed) == 0) {
+					return
+				}
+
+				recvCh := inCh
+				if len(l.delayed) >= maxDelayed {
+					recvCh = nil // wait for the release of a delayed item
+				}
+				select {
+				case value, open := <-recvCh:
+					if !open {
+						inCh = nil
+					} else if !l.recv(value) {
+						go flushChan(in)
+						return
+					}
+				case <-l.timeout:
+					l.timer, l.timeout = nil, nil
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
+
+// rateLimitStage holds the items delayed by a RateLimit stage.
+type rateLimitStage struct {
+	r       *stageRuntime
+	cfg     stageConfig
+	config  RateLimitConfig
+	limiter *rateLimiter
+	outCh   chan<- interface{}
+
+	delayed delayQueue
+	seq     uint64
+	timer   Timer // timer fires when the first delayed item is allowed
+	timerAt time.Time
+	timeout <-chan time.Time
+}
+
+// recv reserves a token for the given value and delays it until the token is available, or drops
+// it. It returns false if the stage must be stopped.
+func (l *rateLimitStage) recv(in interface{}) bool {
+	value, span := l.r.received(in)
+
+	var key interface{}
+	if l.config.Key != nil {
+		ok, policy := l.r.process(span, value, func() { key = l.config.Key(value) })
+		if !ok {
+			span.end(nil)
+			return policy != PanicAbort
+		}
+	}
+
+	at, allowed := l.limiter.reserve(key, l.config.Policy == RateLimitWait)
+	if !allowed {
+		span.end(nil)
+		l.r.drop()
+		return l.cfg.dropped == nil || sendContext(l.r.ctx, l.cfg.dropped, value)
+	}
+
+	l.seq++
+	heap.Push(&l.delayed, &delayedItem{value: value, span: span, at: at, seq: l.seq})
+	return true
+}
+
+// release sends the delayed items which are allowed, and starts the timer firing when the next one
+// is allowed. It returns false if the stage must be stopped.
+func (l *rateLimitStage) release() bool {
+	now := l.r.clock.Now()
+	for len(l.delayed) > 0 && !l.delayed[0].at.After(now) {
+		item := heap.Pop(&l.delayed).(*delayedItem)
+		item.span.end(nil)
+		if !l.r.send(l.outCh, item.span.wrap(item.value)) {
+			return false
+		}
+	}
+
+	switch {
+	case len(l.delayed) == 0:
+		l.stopTimer()
+	case l.timer == nil || !l.timerAt.Equal(l.delayed[0].at):
+		l.stopTimer()
+		l.timerAt = l.delayed[0].at
+		l.timer = l.r.clock.NewTimer(l.timerAt.Sub(l.r.clock.Now()))
+		l.timeout = l.timer.C()
+	}
+	return true
+}
+
+func (l *rateLimitStage) stopTimer() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer, l.timeout = nil, nil
+	}
+}
+
+// delayedItem is an item delayed until its token is available.
+type delayedItem struct {
+	value interface{}
+	span  *itemSpan
+	at    time.Time // at is the time when the token of the item is available
+	seq   uint64
+}
+
+// delayQueue is a heap of delayed items, sorted by availability and then by reception (in order to
+// keep the order of the items of each key).
+type delayQueue []*delayedItem
+
+func (q delayQueue) Len() int { return len(q) }
+func (q delayQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q delayQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *delayQueue) Push(x interface{}) { *q = append(*q, x.(*delayedItem)) }
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+// rateLimiter is a set of token buckets, one per key.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	clock   Clock
+	buckets map[interface{}]*tokenBucket
+	sweep   int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiterSweep is the number of reservations between two removals of the full buckets.
+const rateLimiterSweep = 1024
+
+func newRateLimiter(config RateLimitConfig, clock Clock) *rateLimiter {
+	burst := config.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: config.Rate, burst: float64(burst), clock: clock, buckets: map[interface{}]*tokenBucket{}}
+}
+
+// reserve takes a token from the bucket of the given key and returns the time when it is available.
+// If no token is available, it returns false unless wait is true; the token is then reserved and
+// will be available later.
+func (l *rateLimiter) reserve(key interface{}, wait bool) (time.Time, bool) {
+	now := l.clock.Now()
+	l.removeFullBuckets(now)
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = l.tokens(bucket, now)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return now, true
+	} else if !wait {
+		return now, false
+	}
+
+	bucket.tokens--
+	return now.Add(time.Duration(-bucket.tokens / l.rate * float64(time.Second))), true
+}
+
+// tokens returns the tokens of the given bucket at the given time.
+func (l *rateLimiter) tokens(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.last).Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+	return tokens
+}
+
+// removeFullBuckets regularly removes the full buckets, which are identical to new ones, in order to
+// not keep the buckets of all keys ever seen.
+func (l *rateLimiter) removeFullBuckets(now time.Time) {
+	if l.sweep++; l.sweep < rateLimiterSweep {
+		return
+	}
+
+	l.sweep = 0
+	for key, bucket := range l.buckets {
+		if l.tokens(bucket, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestRateLimit_Wait(t *testing.T) {
+	clock := newFakeClock()
+	p := pipeline.Pipeline{
+		pipeline.RateLimit(pipeline.RateLimitConfig{Rate: 10, Burst: 2}),
+	}
+
+	in := make(chan interface{}, 4)
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+	for i := 0; i < 4; i++ {
+		in <- i
+	}
+	close(in)
+
+	assert.Equal(t, 0, <-exec.Out())
+	assert.Equal(t, 1, <-exec.Out())
+
+	clock.WaitTimers(t, 1) // the burst is exhausted
+	select {
+	case <-exec.Out():
+		t.Errorf("item must be delayed")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, 2, <-exec.Out())
+	clock.WaitTimers(t, 1)
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, 3, <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+}
+
+func TestRateLimit_Drop(t *testing.T) {
+	clock := newFakeClock()
+	dropped := make(chan interface{}, 2)
+	p := pipeline.Pipeline{
+		pipeline.RateLimit(pipeline.RateLimitConfig{Rate: 1, Policy: pipeline.RateLimitDrop}, pipeline.Dropped(dropped)),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	in <- 0
+	assert.Equal(t, 0, <-exec.Out())
+	in <- 1
+	assert.Equal(t, 1, <-dropped)
+
+	clock.Advance(time.Second)
+	in <- 2
+	assert.Equal(t, 2, <-exec.Out())
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestRateLimit_Key(t *testing.T) {
+	dropped := make(chan interface{}, 2)
+	p := pipeline.Pipeline{
+		pipeline.RateLimit(pipeline.RateLimitConfig{
+			Rate:   1,
+			Key:    func(item interface{}) interface{} { return item.(string)[0] },
+			Policy: pipeline.RateLimitDrop,
+		}, pipeline.Dropped(dropped)),
+	}
+
+	in := make(chan interface{}, 4)
+	exec := p.Start(context.Background(), in, pipeline.WithClock(newFakeClock()))
+	for _, item := range []string{"a1", "b1", "a2", "c1"} {
+		in <- item
+	}
+	close(in)
+
+	assert.Equal(t, "a1", <-exec.Out())
+	assert.Equal(t, "b1", <-exec.Out())
+	assert.Equal(t, "c1", <-exec.Out())
+	assert.Equal(t, "a2", <-dropped)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestRateLimit_WaitKey(t *testing.T) {
+	clock := newFakeClock()
+	p := pipeline.Pipeline{
+		pipeline.RateLimit(pipeline.RateLimitConfig{
+			Rate: 10,
+			Key:  func(item interface{}) interface{} { return item.(string)[0] },
+		}),
+	}
+
+	in := make(chan interface{}, 5)
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+	for _, item := range []string{"a1", "a2", "b1", "a3", "b2"} {
+		in <- item
+	}
+	close(in)
+
+	// a2 waits for its token without delaying b1
+	assert.Equal(t, "a1", <-exec.Out())
+	assert.Equal(t, "b1", <-exec.Out())
+
+	clock.WaitTimers(t, 1)
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, "a2", <-exec.Out())
+	assert.Equal(t, "b2", <-exec.Out())
+
+	clock.WaitTimers(t, 1)
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, "a3", <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+}
+
+func TestRateLimit_Cancel(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.RateLimit(pipeline.RateLimitConfig{Rate: 1}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(newFakeClock()))
+	in <- 0
+	in <- 1 // waits for a token
+	exec.Cancel()
+
+	assert.Equal(t, context.Canceled, exec.Wait())
+}
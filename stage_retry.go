@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the delay to wait before the given retry (1 for the first retry).
+type Backoff func(retry int) time.Duration
+
+// ConstantBackoff waits the same delay before each retry.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(int) time.Duration { return delay }
+}
+
+// ExponentialBackoff doubles the delay before each retry, starting with the initial delay and
+// without exceeding the maximum delay (if not zero).
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(retry int) time.Duration {
+		delay := initial
+		for i := 1; i < retry && (max <= 0 || delay < max) && delay < math.MaxInt64/2; i++ {
+			delay *= 2
+		}
+		if max > 0 && delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// JitteredBackoff waits a random delay between zero and the delay of the given backoff, in order to
+// spread the retries of concurrent stages.
+func JitteredBackoff(backoff Backoff) Backoff {
+	return func(retry int) time.Duration {
+		delay := backoff(retry)
+		if delay <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+}
+
+// RetryConfig defines how a Retry stage retries the failed items. A limit set to zero is disabled;
+// without limit, an item is retried until it succeeds or until the pipeline is stopped.
+type RetryConfig struct {
+	Backoff     Backoff              // Backoff defines the delay before each retry (no delay if nil)
+	MaxAttempts int                  // MaxAttempts is the maximum number of calls for an item
+	MaxElapsed  time.Duration        // MaxElapsed is the maximum time spent to process an item
+	Retryable   func(err error) bool // Retryable returns false if an error must not be retried (all are retried if nil)
+	DeadLetter  chan<- *ItemError    // DeadLetter receives the failed items; they are reported to the pipeline if nil
+}
+
+// RetryError is the error of an item which failed with a Retry stage.
+type RetryError struct {
+	Attempts int   // Attempts is the number of calls for the item
+	Err      error // Err is the error of the last call
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last call.
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// Retry is a ConsumerE calling again the given function when it fails, as configured. When an item
+// is not retryable anymore, it is sent with a RetryError to the dead-letter channel, or reported to
+// the pipeline (see Pipeline.Start). The delays are given by the pipeline clock (see WithClock).
+func Retry(fnc func(obj interface{}) (interface{}, error), config RetryConfig, opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if fnc == nil || inCh == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, "retry")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, span, open := r.recv(inCh)
+				if !open {
+					return
+				}
+
+				out, ok, policy, err := retry(r, config, span, in, fnc)
+				span.end(err)
+				switch {
+				case ctx.Err() != nil:
+					return
+				case !ok && policy == PanicAbort:
+					go flushChan(inCh)
+					return
+				case !ok:
+					continue
+				case err != nil && config.DeadLetter != nil:
+					r.drop()
+					select {
+					case config.DeadLetter <- &ItemError{Stage: r.id, Item: in, Err: err}:
+						continue
+					case <-ctx.Done():
+						return
+					}
+				case err != nil:
+					r.reportError(in, err)
+					continue
+				}
+				if !r.send(outCh, span.wrap(out)) {
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
+
+// retry calls fnc until it succeeds or until the item is not retryable anymore (see RetryError). If
+// fnc panicked, it returns false with the policy to apply.
+func retry(r *stageRuntime, config RetryConfig, span *itemSpan, in interface{}, fnc func(obj interface{}) (interface{}, error)) (out interface{}, ok bool, policy PanicPolicy, err error) {
+	start := r.clock.Now()
+	for attempt := 1; ; attempt++ {
+		retryable := false
+		ok, policy = r.process(span, in, func() {
+			out, err = fnc(in)
+			retryable = err != nil && (config.Retryable == nil || config.Retryable(err))
+		})
+		if !ok || err == nil {
+			return out, ok, policy, nil
+		}
+
+		var delay time.Duration
+		if config.Backoff != nil {
+			delay = config.Backoff(attempt)
+		}
+		if !retryable ||
+			(config.MaxAttempts > 0 && attempt >= config.MaxAttempts) ||
+			(config.MaxElapsed > 0 && r.clock.Now().Sub(start)+delay > config.MaxElapsed) {
+			return nil, true, policy, &RetryError{Attempts: attempt, Err: err}
+		}
+		if !sleepContext(r.ctx, r.clock, delay) {
+			return nil, true, policy, r.ctx.Err()
+		}
+	}
+}
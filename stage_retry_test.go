@@ -0,0 +1,135 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+var errTransient = errors.New("transient failure")
+
+// failingUntil returns a function failing until it is called n times for the same item.
+func failingUntil(n int) func(obj interface{}) (interface{}, error) {
+	calls := map[interface{}]int{}
+	return func(obj interface{}) (interface{}, error) {
+		if calls[obj]++; calls[obj] < n {
+			return nil, errTransient
+		}
+		return obj, nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Retry(failingUntil(3), pipeline.RetryConfig{MaxAttempts: 3}).Run(in)
+
+	in <- 0
+	assert.Equal(t, 0, <-out)
+	close(in)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestRetry_DeadLetter(t *testing.T) {
+	errFatal := errors.New("fatal failure")
+	deadLetter := make(chan *pipeline.ItemError, 2)
+	p := pipeline.Pipeline{
+		pipeline.Retry(func(obj interface{}) (interface{}, error) {
+			if obj.(int) < 0 {
+				return nil, errFatal
+			}
+			return nil, errTransient
+		}, pipeline.RetryConfig{
+			MaxAttempts: 4,
+			Retryable:   func(err error) bool { return err != errFatal },
+			DeadLetter:  deadLetter,
+		}),
+	}
+
+	in := make(chan interface{}, 2)
+	in <- 1
+	in <- -1
+	close(in)
+	exec := p.Start(context.Background(), in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+
+	assert.Equal(t, &pipeline.ItemError{Stage: "0.retry", Item: 1, Err: &pipeline.RetryError{Attempts: 4, Err: errTransient}}, <-deadLetter)
+	assert.Equal(t, &pipeline.ItemError{Stage: "0.retry", Item: -1, Err: &pipeline.RetryError{Attempts: 1, Err: errFatal}}, <-deadLetter)
+}
+
+func TestRetry_ReportError(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Retry(failingUntil(10), pipeline.RetryConfig{MaxAttempts: 2}),
+	}
+
+	in := make(chan interface{}, 1)
+	in <- 0
+	exec := p.Start(context.Background(), in)
+
+	err := exec.Wait()
+	assert.True(t, errors.Is(err, errTransient))
+	assert.EqualError(t, err, "0.retry: failed after 2 attempts: transient failure")
+}
+
+func TestRetry_MaxElapsed(t *testing.T) {
+	clock := newFakeClock()
+	deadLetter := make(chan *pipeline.ItemError, 1)
+	p := pipeline.Pipeline{
+		pipeline.Retry(failingUntil(10), pipeline.RetryConfig{
+			Backoff:    pipeline.ConstantBackoff(time.Second),
+			MaxElapsed: 2500 * time.Millisecond,
+			DeadLetter: deadLetter,
+		}),
+	}
+
+	in := make(chan interface{}, 1)
+	in <- 0
+	close(in)
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	for i := 0; i < 2; i++ {
+		clock.WaitTimers(t, 1)
+		clock.Advance(time.Second)
+	}
+
+	err := <-deadLetter
+	assert.Equal(t, &pipeline.RetryError{Attempts: 3, Err: errTransient}, err.Err)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestRetry_Cancel(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Retry(failingUntil(10), pipeline.RetryConfig{Backoff: pipeline.ConstantBackoff(time.Hour)}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+	in <- 0
+	exec.Cancel()
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, context.Canceled, exec.Wait())
+}
+
+func TestBackoff(t *testing.T) {
+	exponential := pipeline.ExponentialBackoff(100*time.Millisecond, time.Second)
+	assert.Equal(t, 100*time.Millisecond, exponential(1))
+	assert.Equal(t, 200*time.Millisecond, exponential(2))
+	assert.Equal(t, 800*time.Millisecond, exponential(4))
+	assert.Equal(t, time.Second, exponential(5))
+	assert.Equal(t, time.Second, exponential(1000))
+
+	assert.True(t, pipeline.ExponentialBackoff(time.Second, 0)(1000) > 0)
+	assert.Equal(t, time.Second, pipeline.ConstantBackoff(time.Second)(10))
+
+	jittered := pipeline.JitteredBackoff(pipeline.ConstantBackoff(time.Second))
+	for i := 0; i < 100; i++ {
+		delay := jittered(1)
+		assert.True(t, delay >= 0 && delay <= time.Second)
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Route sends the values matching its predicate to its pipeline (see Router).
+type Route struct {
+	Name      string // Name identifies the route in the stage IDs and counters (its index by default)
+	Predicate Predicate
+	Pipeline  Pipeline
+}
+
+// Router sends each value of the input channel to the pipeline of the first route whose predicate
+// returns true, or to the default pipeline if none matches, and merges their outputs like LRFilter.
+// An empty pipeline sends its values directly to the next stage. Each value increments the counter
+// of its route (see CounterInstrumentation), the default route being named "default".
+func Router(routes []Route, defaultRoute Pipeline, opts ...StageOption) Stage {
+	names := make([]string, len(routes)+1)
+	pipelines := make([]Pipeline, len(routes)+1)
+	for i, route := range routes {
+		names[i], pipelines[i] = route.Name, route.Pipeline
+		if names[i] == "" {
+			names[i] = strconv.Itoa(i)
+		}
+	}
+	names[len(routes)], pipelines[len(routes)] = "default", defaultRoute
+
+	return router("router", names, pipelines, func(item interface{}) int {
+		for i, route := range routes {
+			if route.Predicate != nil && route.Predicate(item) {
+				return i
+			}
+		}
+		return len(routes)
+	}, opts)
+}
+
+// RouterByKey sends each value of the input channel to the pipeline of its key, or to the default
+// pipeline if its key has no route, like Router. The routes are named after their key.
+func RouterByKey(key func(item interface{}) interface{}, routes map[interface{}]Pipeline, defaultRoute Pipeline, opts ...StageOption) Stage {
+	keys := make([]interface{}, 0, len(routes))
+	for k := range routes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+
+	names := make([]string, len(keys)+1)
+	pipelines := make([]Pipeline, len(keys)+1)
+	indexes := make(map[interface{}]int, len(keys))
+	for i, k := range keys {
+		names[i], pipelines[i], indexes[k] = fmt.Sprint(k), routes[k], i
+	}
+	names[len(keys)], pipelines[len(keys)] = "default", defaultRoute
+
+	if key == nil {
+		return router("router", names, pipelines, nil, opts)
+	}
+	return router("router", names, pipelines, func(item interface{}) int {
+		if i, exists := indexes[key(item)]; exists {
+			return i
+		}
+		return len(keys)
+	}, opts)
+}
+
+// router sends each value to the pipeline at the index returned by route.
+func router(kind string, names []string, pipelines []Pipeline, route func(item interface{}) int, opts []StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if route == nil || !hasRunnablePipeline(pipelines) || in == nil {
+			return in
+		}
+
+		r := cfg.start(ctx, kind)
+		chs := make([]chan interface{}, len(pipelines))
+		out := make(chan interface{}, cfg.chanSize(ctx, cap(in)))
+
+		wg := &sync.WaitGroup{}
+		wg.Add(len(pipelines))
+		for i, pipeline := range pipelines {
+			chs[i] = make(chan interface{}, cfg.chanSize(ctx, (cap(in)/len(pipelines))+1))
+			go runPipeline(r.scope(names[i]), r, pipeline, wg, chs[i], out)
+		}
+
+		go func() {
+			defer func() {
+				for _, ch := range chs {
+					close(ch)
+				}
+			}()
+			defer flushOnCancel(ctx, in)
+
+			for {
+				value, span, open := r.recv(in)
+				if !open {
+					return
+				}
+
+				var i int
+				ok, policy := r.process(span, value, func() { i = route(value) })
+				span.end(nil)
+				if !ok && policy == PanicAbort {
+					go flushChan(in)
+					return
+				} else if !ok {
+					continue
+				}
+
+				r.count(names[i])
+				if !r.dispatch(chs[i], span.wrap(value)) {
+					return
+				}
+			}
+		}()
+		go func() { wg.Wait(); close(out) }() // Close out only when all goroutine are stopped
+		return out
+	})
+}
+
+// hasRunnablePipeline returns true if at least one of the given pipelines has stages to run.
+func hasRunnablePipeline(pipelines []Pipeline) bool {
+	for _, pipeline := range pipelines {
+		if len(pipeline) > 0 && !hasNilStage(pipeline) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestRouter(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Router(
+			[]pipeline.Route{
+				{Name: "negative", Predicate: func(i interface{}) bool { return i.(int) < 0 },
+					Pipeline: pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return -obj.(int) })}},
+				{Predicate: func(i interface{}) bool { return i.(int) == 0 },
+					Pipeline: pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return "zero" })}},
+			},
+			pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 })},
+		),
+	}
+
+	collector := pipeline.NewCollector()
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithInstrumentation(collector))
+
+	in <- -1
+	assert.Equal(t, 1, <-exec.Out())
+	in <- 0
+	assert.Equal(t, "zero", <-exec.Out())
+	in <- 2
+	assert.Equal(t, 4, <-exec.Out())
+	in <- -3
+	assert.Equal(t, 3, <-exec.Out())
+
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+
+	snapshot := collector.Snapshot()
+	assert.Equal(t, map[string]uint64{"negative": 2, "1": 1, "default": 1}, snapshot["0.router"].Counters)
+	assert.Equal(t, uint64(2), snapshot["0.router/negative/0.consumer"].ItemsIn)
+}
+
+func TestRouter_NoDefault(t *testing.T) {
+	r := pipeline.Router(
+		[]pipeline.Route{
+			{Predicate: func(i interface{}) bool { return i.(int) < 0 },
+				Pipeline: pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return -obj.(int) })}},
+		},
+		nil,
+	)
+
+	in := make(chan interface{})
+	out := r.Run(in)
+
+	in <- 2 // the unmatched values are sent to the next stage
+	assert.Equal(t, 2, <-out)
+	in <- -2
+	assert.Equal(t, 2, <-out)
+
+	close(in)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestRouter_NoPipeline(t *testing.T) {
+	r := pipeline.Router([]pipeline.Route{{Predicate: func(i interface{}) bool { return true }}}, nil)
+
+	in := make(chan interface{})
+	out := r.Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestRouterByKey(t *testing.T) {
+	type event struct{ kind, id string }
+
+	p := pipeline.Pipeline{
+		pipeline.RouterByKey(
+			func(item interface{}) interface{} { return item.(event).kind },
+			map[interface{}]pipeline.Pipeline{
+				"created": {pipeline.C(func(obj interface{}) interface{} { return "create " + obj.(event).id })},
+				"deleted": {pipeline.C(func(obj interface{}) interface{} { return "delete " + obj.(event).id })},
+			},
+			pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return "ignore " + obj.(event).id })},
+		),
+	}
+
+	collector := pipeline.NewCollector()
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithInstrumentation(collector))
+
+	in <- event{"created", "a"}
+	assert.Equal(t, "create a", <-exec.Out())
+	in <- event{"updated", "a"}
+	assert.Equal(t, "ignore a", <-exec.Out())
+	in <- event{"deleted", "a"}
+	assert.Equal(t, "delete a", <-exec.Out())
+
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, map[string]uint64{"created": 1, "deleted": 1, "default": 1}, collector.Snapshot()["0.router"].Counters)
+}
+
+func TestRouterByKey_NilKey(t *testing.T) {
+	r := pipeline.RouterByKey(nil, map[interface{}]pipeline.Pipeline{
+		"a": {pipeline.C(func(obj interface{}) interface{} { return obj })},
+	}, nil)
+
+	in := make(chan interface{})
+	out := r.Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestRouter_Cancel(t *testing.T) {
+	r := pipeline.Router(
+		[]pipeline.Route{
+			{Predicate: func(i interface{}) bool { return i.(int) < 0 },
+				Pipeline: pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj })}},
+		},
+		pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj })},
+	)
+
+	in := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	out := r.(pipeline.ContextStage).RunContext(ctx, in)
+
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+	select {
+	case in <- 1: // the input channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must not be blocked")
+	}
+}
@@ -0,0 +1,357 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// stageRuntime is the state shared by the goroutines of a running built-in stage. All values
+// received and sent by a built-in stage go through its runtime, which reports them to the pipeline
+// instrumentation (see WithInstrumentation) and opens their spans (see WithTracer).
+type stageRuntime struct {
+	ctx    context.Context
+	id     string
+	instr  Instrumentation
+	tracer Tracer
+	clock  Clock
+}
+
+// stageScope locates a stage inside a pipeline. The ID of a stage is composed of the ID of its
+// parent stage and of its index in the parent pipeline (if any), followed by its name.
+type stageScope struct {
+	path  string
+	index int
+}
+
+type stageScopeKey struct{}
+
+func scopeFrom(ctx context.Context) stageScope {
+	if scope, exists := ctx.Value(stageScopeKey{}).(stageScope); exists {
+		return scope
+	}
+	return stageScope{index: -1}
+}
+
+// withStageIndex returns a context for the stage at the given index of a pipeline.
+func withStageIndex(ctx context.Context, index int) context.Context {
+	scope := scopeFrom(ctx)
+	scope.index = index
+	return context.WithValue(ctx, stageScopeKey{}, scope)
+}
+
+// start returns the runtime of a stage started with the given context.
+func (cfg stageConfig) start(ctx context.Context, kind string) *stageRuntime {
+	name := kind
+	if cfg.name != "" {
+		name = cfg.name
+	}
+
+	scope := scopeFrom(ctx)
+	if scope.index >= 0 {
+		name = strconv.Itoa(scope.index) + "." + name
+	}
+	if scope.path != "" {
+		name = scope.path + "/" + name
+	}
+
+	r := &stageRuntime{ctx: ctx, id: name, clock: clockFrom(ctx)}
+	if exec := executionFrom(ctx); exec != nil {
+		r.instr, r.tracer = exec.instr, exec.tracer
+	}
+	return r
+}
+
+// StageRuntime is the runtime of a custom stage started with StartStage, giving it the name and the
+// channel capacity of a built-in stage configured with the same options. Like a built-in stage, the
+// custom stage reports its items through its runtime to the pipeline instrumentation (see
+// WithInstrumentation) and tracer (see WithTracer).
+type StageRuntime struct {
+	r   *stageRuntime
+	cfg stageConfig
+}
+
+// StartStage returns the runtime of a custom stage of the given kind (its default name), started
+// with the given context and configured with the given options (see Name and BufferSize).
+func StartStage(ctx context.Context, kind string, opts ...StageOption) *StageRuntime {
+	cfg := newStageConfig(opts)
+	return &StageRuntime{r: cfg.start(ctx, kind), cfg: cfg}
+}
+
+// Name returns the name identifying the stage in the pipeline, to be given to Protect and
+// ReportError.
+func (s *StageRuntime) Name() string { return s.r.id }
+
+// ChanSize returns the capacity of the channels created by the stage. The default capacity is used
+// if neither the stage (see BufferSize) nor the pipeline (see WithBufferSize) defines it.
+func (s *StageRuntime) ChanSize(defaultSize int) int { return s.cfg.chanSize(s.r.ctx, defaultSize) }
+
+// Scope returns the context used to run the sub-stages of the stage, located under this stage and
+// the given segment (if not empty).
+func (s *StageRuntime) Scope(segment string) context.Context { return s.r.scope(segment) }
+
+// Reporting returns false if the pipeline has neither instrumentation nor tracer, in which case the
+// stage can skip its reports.
+func (s *StageRuntime) Reporting() bool { return s.r.instr != nil || s.r.tracer != nil }
+
+// Received reports an input received by the stage and opens its span, which must be ended by the
+// stage. The custom stages only receive the value of the items: the span starts a new trace.
+func (s *StageRuntime) Received() *ItemSpan {
+	_, span := s.r.received(nil)
+	return (*ItemSpan)(span)
+}
+
+// Process calls fnc like Protect to process the given input, and reports its processing time. The
+// span of the input fails if fnc panicked.
+func (s *StageRuntime) Process(span *ItemSpan, item interface{}, fnc func()) (ok bool, policy PanicPolicy) {
+	return s.r.process((*itemSpan)(span), item, fnc)
+}
+
+// ReportError reports an error of the stage like ReportError, and the input as dropped.
+func (s *StageRuntime) ReportError(item interface{}, err error) { s.r.reportError(item, err) }
+
+// Sent reports an output sent by the stage, with the time when its sending started and the number
+// of items waiting in the output channel once sent.
+func (s *StageRuntime) Sent(start time.Time, depth int) {
+	if s.r.instr != nil {
+		s.Dispatched(start, depth)
+		s.r.instr.ItemOut(s.r.id)
+	}
+}
+
+// Dispatched reports an input sent by the stage to one of its branches (like the stages of a Fork),
+// with the time when its sending started and the number of items waiting in the branch channel once
+// sent.
+func (s *StageRuntime) Dispatched(start time.Time, depth int) {
+	if s.r.instr != nil {
+		s.r.instr.SendBlocked(s.r.id, time.Since(start))
+		s.r.instr.QueueDepth(s.r.id, depth)
+	}
+}
+
+// Worker reports that a worker of the stage starts (delta = 1) or stops (delta = -1).
+func (s *StageRuntime) Worker(delta int) { s.r.worker(delta) }
+
+// scope returns the context used to run the sub-stages, located under this stage and the given
+// segment (if not empty).
+func (r *stageRuntime) scope(segment string) context.Context {
+	path := r.id
+	if segment != "" {
+		path += "/" + segment
+	}
+	return context.WithValue(r.ctx, stageScopeKey{}, stageScope{path: path, index: -1})
+}
+
+// recv receives the next input of the stage and opens its span, which must be ended by the stage.
+func (r *stageRuntime) recv(inCh <-chan interface{}) (interface{}, *itemSpan, bool) {
+	in, open := recvContext(r.ctx, inCh)
+	if !open {
+		return nil, nil, false
+	}
+
+	value, span := r.received(in)
+	return value, span, true
+}
+
+// received reports an input received by the stage without recv and opens its span, which must be
+// ended by the stage.
+func (r *stageRuntime) received(in interface{}) (interface{}, *itemSpan) {
+	if r.instr != nil {
+		r.instr.ItemIn(r.id)
+	}
+
+	value, parent := untrace(in)
+	return value, r.startSpan(parent)
+}
+
+// startSpan opens a span of the stage (nil if the pipeline is not traced).
+func (r *stageRuntime) startSpan(parent SpanContext) *itemSpan {
+	if r.tracer == nil {
+		return nil
+	}
+	return &itemSpan{span: r.tracer.StartSpan(r.id, parent)}
+}
+
+// send sends an output of the stage.
+func (r *stageRuntime) send(outCh chan<- interface{}, out interface{}) bool {
+	if !r.dispatch(outCh, out) {
+		return false
+	}
+	if r.instr != nil {
+		r.instr.ItemOut(r.id)
+	}
+	return true
+}
+
+// dispatch sends an input of the stage to one of its branches (like the stages of a Fork), which
+// sends the outputs itself.
+func (r *stageRuntime) dispatch(ch chan<- interface{}, in interface{}) bool {
+	if r.instr == nil {
+		return sendContext(r.ctx, ch, in)
+	}
+
+	start := time.Now()
+	if !sendContext(r.ctx, ch, in) {
+		return false
+	}
+	r.instr.SendBlocked(r.id, time.Since(start))
+	r.instr.QueueDepth(r.id, len(ch))
+	return true
+}
+
+// drop reports that an item has been dropped by the stage.
+func (r *stageRuntime) drop() {
+	if r.instr != nil {
+		r.instr.ItemDropped(r.id)
+	}
+}
+
+// worker reports that a worker of the stage starts (delta = 1) or stops (delta = -1).
+func (r *stageRuntime) worker(delta int) {
+	if r.instr != nil {
+		r.instr.WorkerActive(r.id, delta)
+	}
+}
+
+// count increments the given counter of the stage, if supported by the instrumentation (see
+// CounterInstrumentation).
+func (r *stageRuntime) count(counter string) {
+	if counters, ok := r.instr.(CounterInstrumentation); ok {
+		counters.Count(r.id, counter)
+	}
+}
+
+// process calls fnc to process the given item (see Protect). If fnc panicked, the item is dropped
+// and the panic fails its span.
+func (r *stageRuntime) process(span *itemSpan, item interface{}, fnc func()) (bool, PanicPolicy) {
+	start := time.Now()
+	err, policy := protect(r.ctx, r.id, item, fnc)
+	if r.instr != nil {
+		r.instr.ItemProcessed(r.id, time.Since(start))
+	}
+	if err != nil {
+		r.drop()
+		span.fail(err)
+	}
+	return err == nil, policy
+}
+
+// reportError drops the given item and reports the error (see ReportError).
+func (r *stageRuntime) reportError(item interface{}, err error) {
+	r.drop()
+	ReportError(r.ctx, r.id, item, err)
+}
+
+// fail stops the pipeline running the stage with the given error, whatever its error mode.
+func (r *stageRuntime) fail(item interface{}, err error) {
+	r.drop()
+	if exec := executionFrom(r.ctx); exec != nil {
+		exec.fail(&ItemError{Stage: r.id, Item: item, Err: err})
+	}
+}
+
+// forward sends all values of the given channel as outputs of the stage.
+func (r *stageRuntime) forward(values <-chan interface{}, outCh chan<- interface{}) {
+	defer flushOnCancel(r.ctx, values)
+
+	for {
+		value, open := recvContext(r.ctx, values)
+		if !open || !r.send(outCh, value) {
+			return
+		}
+	}
+}
+
+// emit sends all values of the given channel as new items of the stage, each one starting a new
+// trace.
+func (r *stageRuntime) emit(values <-chan interface{}, outCh chan<- interface{}) {
+	defer flushOnCancel(r.ctx, values)
+
+	for {
+		value, open := recvContext(r.ctx, values)
+		if !open {
+			return
+		}
+
+		span := r.startSpan(SpanContext{})
+		span.end(nil)
+		if !r.send(outCh, span.wrap(value)) {
+			return
+		}
+	}
+}
+
+// observeChan returns a channel forwarding all values of the given channel, in order to report the
+// inputs of a stage which doesn't read its input channel itself.
+func (r *stageRuntime) observeChan(inCh <-chan interface{}) <-chan interface{} {
+	if r.instr == nil && r.tracer == nil {
+		return inCh
+	}
+
+	outCh := make(chan interface{}, cap(inCh))
+	go func() {
+		defer close(outCh)
+		defer flushOnCancel(r.ctx, inCh)
+
+		for {
+			in, span, open := r.recv(inCh)
+			if !open {
+				return
+			}
+
+			span.end(nil)
+			if !sendContext(r.ctx, outCh, span.wrap(in)) {
+				return
+			}
+		}
+	}()
+	return outCh
+}
+
+// multiplex sends all inputs of the stage to all given channels.
+func (r *stageRuntime) multiplex(inCh <-chan interface{}, outChs ...chan interface{}) {
+	defer flushOnCancel(r.ctx, inCh)
+
+	for {
+		in, span, open := r.recv(inCh)
+		if !open {
+			break
+		}
+
+		span.end(nil)
+		for _, chOut := range outChs {
+			if !r.dispatch(chOut, span.wrap(in)) {
+				break
+			}
+		}
+	}
+
+	for _, chOut := range outChs {
+		close(chOut)
+	}
+}
+
+// multiplexNoLock sends all inputs of the stage to all given channels, without blocking; values are
+// dropped if a channel is blocked.
+func (r *stageRuntime) multiplexNoLock(inCh <-chan interface{}, outChs ...chan interface{}) {
+	defer flushOnCancel(r.ctx, inCh)
+
+	for {
+		in, open := recvContext(r.ctx, inCh)
+		if !open {
+			break
+		}
+
+		for _, chOut := range outChs {
+			select {
+			case chOut <- in:
+			default:
+				r.drop()
+			}
+		}
+	}
+
+	for _, chOut := range outChs {
+		close(chOut)
+	}
+}
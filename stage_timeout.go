@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is reported by Timeout when an item takes too long to be processed.
+var ErrTimeout = errors.New("timeout exceeded")
+
+// Timeout is a ConsumerE bounding the time spent to process each item. The given function receives
+// a context which is cancelled when the timeout expires; the item is then reported to the pipeline
+// with ErrTimeout, or sent to the Dropped channel if defined, and the stage processes the next item
+// without waiting for the function to return. The time is given by the pipeline clock (see
+// WithClock); a timeout lower or equal to zero is disabled.
+func Timeout(timeout time.Duration, fnc func(ctx context.Context, obj interface{}) (interface{}, error), opts ...StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if fnc == nil || inCh == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, "timeout")
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, span, open := r.recv(inCh)
+				if !open {
+					return
+				}
+
+				out, ok, policy, err := callTimeout(r, timeout, span, in, fnc)
+				span.end(err)
+				switch {
+				case ctx.Err() != nil:
+					return
+				case !ok && policy == PanicAbort:
+					go flushChan(inCh)
+					return
+				case !ok:
+					continue
+				case err == ErrTimeout && cfg.dropped != nil:
+					r.drop()
+					if !sendContext(ctx, cfg.dropped, in) {
+						return
+					}
+					continue
+				case err != nil:
+					r.reportError(in, err)
+					continue
+				}
+				if !r.send(outCh, span.wrap(out)) {
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
+
+// timeoutResult is the result of a function called by callTimeout.
+type timeoutResult struct {
+	out    interface{}
+	err    error
+	ok     bool
+	policy PanicPolicy
+	panic  error
+}
+
+// callTimeout calls fnc in a new goroutine and waits until it returns or until the timeout expires.
+// If fnc panicked, it returns false with the policy to apply.
+func callTimeout(r *stageRuntime, timeout time.Duration, span *itemSpan, in interface{}, fnc func(ctx context.Context, obj interface{}) (interface{}, error)) (out interface{}, ok bool, policy PanicPolicy, err error) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+
+	results := make(chan timeoutResult, 1)
+	go func() {
+		// the panic is recorded on a span owned by this goroutine, because the item span can be
+		// ended before fnc returns
+		var res timeoutResult
+		panicked := &itemSpan{}
+		res.ok, res.policy = r.process(panicked, in, func() { res.out, res.err = fnc(ctx, in) })
+		res.panic = panicked.err
+		results <- res
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := r.clock.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C()
+	}
+
+	select {
+	case res := <-results:
+		span.fail(res.panic)
+		return res.out, res.ok, res.policy, res.err
+	case <-expired:
+		return nil, true, policy, ErrTimeout
+	case <-r.ctx.Done():
+		return nil, true, policy, r.ctx.Err()
+	}
+}
@@ -0,0 +1,82 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// slowUntilCancelled blocks for each item equal to "slow" until its context is cancelled.
+func slowUntilCancelled(cancelled chan<- interface{}) func(ctx context.Context, obj interface{}) (interface{}, error) {
+	return func(ctx context.Context, obj interface{}) (interface{}, error) {
+		if obj != "slow" {
+			return obj, nil
+		}
+
+		<-ctx.Done()
+		cancelled <- obj
+		return nil, ctx.Err()
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	clock := newFakeClock()
+	cancelled := make(chan interface{}, 1)
+	p := pipeline.Pipeline{
+		pipeline.Timeout(time.Second, slowUntilCancelled(cancelled)),
+	}
+
+	in := make(chan interface{}, 2)
+	in <- "slow"
+	in <- "fast"
+	close(in)
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock), pipeline.WithErrorMode(pipeline.ContinueOnError))
+
+	clock.WaitTimers(t, 1)
+	clock.Advance(time.Second)
+	assert.Equal(t, &pipeline.ItemError{Stage: "0.timeout", Item: "slow", Err: pipeline.ErrTimeout}, <-exec.Errors())
+	assert.Equal(t, "slow", <-cancelled)
+
+	assert.Equal(t, "fast", <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestTimeout_Dropped(t *testing.T) {
+	clock := newFakeClock()
+	dropped := make(chan interface{}, 1)
+	p := pipeline.Pipeline{
+		pipeline.Parallelize(2, pipeline.Timeout(time.Second, slowUntilCancelled(make(chan interface{}, 2)), pipeline.Dropped(dropped))),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	in <- "slow"
+	clock.WaitTimers(t, 1)
+	in <- "fast" // the other worker is not blocked
+	assert.Equal(t, "fast", <-exec.Out())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "slow", <-dropped)
+	close(in)
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+}
+
+func TestTimeout_Cancel(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.Timeout(time.Hour, slowUntilCancelled(make(chan interface{}, 1))),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+	in <- "slow"
+	exec.Cancel()
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, context.Canceled, exec.Wait())
+}
@@ -0,0 +1,257 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Window is a group of items received during a time range.
+type Window struct {
+	Start time.Time // Start is the beginning of the window (inclusive)
+	End   time.Time // End is the end of the window (exclusive)
+	Items []interface{}
+}
+
+// Aggregate returns the result of a window, sent to the next stage.
+type Aggregate func(window Window) interface{}
+
+// EventTimeConfig defines how the window stages use the time carried by the items (event time),
+// instead of the time when they are received (processing time).
+type EventTimeConfig struct {
+	Timestamp func(item interface{}) time.Time // Timestamp returns the time of an item
+	// MaxOutOfOrderness is the maximum delay of an item compared to the latest item. The watermark of
+	// the stage, which is the latest time minus this delay, defines which windows are ended.
+	MaxOutOfOrderness time.Duration
+	// Late receives the items arriving after the end of all their windows; they are dropped if nil.
+	Late chan<- interface{}
+}
+
+// EventTime defines that a window stage uses the time carried by the items, as configured. The
+// windows are then aggregated when the watermark reaches their end, instead of depending on the
+// pipeline clock.
+func EventTime(config EventTimeConfig) StageOption {
+	return func(cfg *stageConfig) { cfg.eventTime = &config }
+}
+
+// TumblingWindow groups the items into consecutive windows of the given size, aligned on the zero
+// time, and sends the result of the given aggregate function for each window once it ends. The time
+// is given by the pipeline clock (see WithClock), or by the items (see EventTime); windows without
+// item are ignored and the pending windows are aggregated when the input channel is closed.
+func TumblingWindow(size time.Duration, aggregate Aggregate, opts ...StageOption) Stage {
+	if size <= 0 {
+		return windowStage("tumbling", nil, aggregate, opts)
+	}
+	return SlidingWindow(size, size, aggregate, opts...)
+}
+
+// SlidingWindow groups the items into windows of the given size, starting every slide, like
+// TumblingWindow; an item belongs to all windows overlapping its time.
+func SlidingWindow(size, slide time.Duration, aggregate Aggregate, opts ...StageOption) Stage {
+	if size <= 0 || slide <= 0 {
+		return windowStage("sliding", nil, aggregate, opts)
+	}
+
+	kind := "sliding"
+	if size == slide {
+		kind = "tumbling"
+	}
+	return windowStage(kind, func(windows []*Window, t time.Time, item interface{}, watermark time.Time) ([]*Window, bool) {
+		assigned := false
+		for start := t.Truncate(slide); start.After(t.Add(-size)); start = start.Add(-slide) {
+			if start.Add(size).After(watermark) {
+				windows, assigned = addToWindow(windows, start, start.Add(size), item), true
+			}
+		}
+		return windows, assigned
+	}, aggregate, opts)
+}
+
+// SessionWindow groups the items into sessions, like TumblingWindow; a session ends when no item is
+// received during the given gap.
+func SessionWindow(gap time.Duration, aggregate Aggregate, opts ...StageOption) Stage {
+	if gap <= 0 {
+		return windowStage("session", nil, aggregate, opts)
+	}
+
+	return windowStage("session", func(windows []*Window, t time.Time, item interface{}, watermark time.Time) ([]*Window, bool) {
+		session := &Window{Start: t, End: t.Add(gap)}
+		if !session.End.After(watermark) {
+			return windows, false
+		}
+
+		// merge all sessions overlapping the new one
+		pending := windows[:0]
+		for _, window := range windows {
+			if !window.Start.Before(session.End) || !t.Before(window.End) {
+				pending = append(pending, window)
+				continue
+			}
+
+			session.Items = append(session.Items, window.Items...)
+			if window.Start.Before(session.Start) {
+				session.Start = window.Start
+			}
+			if window.End.After(session.End) {
+				session.End = window.End
+			}
+		}
+		session.Items = append(session.Items, item)
+		return append(pending, session), true
+	}, aggregate, opts)
+}
+
+// assignWindow adds an item of the given time to the pending windows ending after the watermark, and
+// returns them. It returns false if the item doesn't belong to any of these windows.
+type assignWindow func(windows []*Window, t time.Time, item interface{}, watermark time.Time) ([]*Window, bool)
+
+// addToWindow adds the given item to the pending window with the given bounds, created if needed.
+func addToWindow(windows []*Window, start, end time.Time, item interface{}) []*Window {
+	for _, window := range windows {
+		if window.Start.Equal(start) {
+			window.Items = append(window.Items, item)
+			return windows
+		}
+	}
+	return append(windows, &Window{Start: start, End: end, Items: []interface{}{item}})
+}
+
+func windowStage(kind string, assign assignWindow, aggregate Aggregate, opts []StageOption) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if assign == nil || aggregate == nil || inCh == nil {
+			return inCh
+		}
+
+		r := cfg.start(ctx, kind)
+		outCh := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			w := &windower{r: r, assign: assign, aggregate: aggregate, outCh: outCh}
+			if cfg.eventTime != nil && cfg.eventTime.Timestamp != nil {
+				w.eventTime = cfg.eventTime
+			}
+			defer w.stopTimer()
+
+			for {
+				select {
+				case in, open := <-inCh:
+					if !open {
+						w.emit(func(*Window) bool { return true })
+						return
+					}
+					if !w.add(in) {
+						go flushChan(inCh)
+						return
+					}
+				case <-w.timeout:
+					if !w.emitEnded() {
+						go flushChan(inCh)
+						return
+					}
+					w.resetTimer()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
+
+// windower holds the pending windows of a window stage.
+type windower struct {
+	r         *stageRuntime
+	assign    assignWindow
+	aggregate Aggregate
+	outCh     chan<- interface{}
+
+	windows []*Window // windows are the pending windows, sorted by end
+	timer   Timer     // timer fires when the first pending window ends (with processing time)
+	timeout <-chan time.Time
+
+	eventTime *EventTimeConfig
+	watermark time.Time
+}
+
+// add adds the given input to its windows. It returns false if the stage must be stopped.
+func (w *windower) add(in interface{}) bool {
+	value, span := w.r.received(in)
+	defer span.end(nil)
+
+	if w.eventTime == nil {
+		if !w.emitEnded() {
+			return false
+		}
+
+		w.windows, _ = w.assign(w.windows, w.r.clock.Now(), value, time.Time{})
+		w.sortWindows()
+		w.resetTimer()
+		return true
+	}
+
+	var t time.Time
+	ok, policy := w.r.process(span, value, func() { t = w.eventTime.Timestamp(value) })
+	if !ok {
+		return policy != PanicAbort
+	}
+
+	var assigned bool
+	if w.windows, assigned = w.assign(w.windows, t, value, w.watermark); !assigned {
+		w.r.drop()
+		return w.eventTime.Late == nil || sendContext(w.r.ctx, w.eventTime.Late, value)
+	}
+	w.sortWindows()
+
+	if watermark := t.Add(-w.eventTime.MaxOutOfOrderness); watermark.After(w.watermark) {
+		w.watermark = watermark
+	}
+	return w.emit(func(window *Window) bool { return !w.watermark.Before(window.End) })
+}
+
+func (w *windower) sortWindows() {
+	sort.SliceStable(w.windows, func(i, j int) bool { return w.windows[i].End.Before(w.windows[j].End) })
+}
+
+// emitEnded aggregates the windows ended at the current time. It returns false if the stage must be
+// stopped.
+func (w *windower) emitEnded() bool {
+	now := w.r.clock.Now()
+	return w.emit(func(window *Window) bool { return !now.Before(window.End) })
+}
+
+// emit aggregates the pending windows, in order, while ended returns true. It returns false if the
+// stage must be stopped.
+func (w *windower) emit(ended func(*Window) bool) bool {
+	for len(w.windows) > 0 && ended(w.windows[0]) {
+		window := w.windows[0]
+		w.windows = w.windows[1:]
+
+		var out interface{}
+		ok, policy := w.r.process(nil, window.Items, func() { out = w.aggregate(*window) })
+		if !ok && policy == PanicAbort {
+			return false
+		} else if ok && !w.r.send(w.outCh, out) {
+			return false
+		}
+	}
+	return true
+}
+
+// resetTimer starts the timer firing when the first pending window ends.
+func (w *windower) resetTimer() {
+	w.stopTimer()
+	if len(w.windows) > 0 {
+		w.timer = w.r.clock.NewTimer(w.windows[0].End.Sub(w.r.clock.Now()))
+		w.timeout = w.timer.C()
+	}
+}
+
+func (w *windower) stopTimer() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer, w.timeout = nil, nil
+	}
+}
@@ -0,0 +1,176 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func windowOf(start, end time.Duration, items ...interface{}) pipeline.Window {
+	return pipeline.Window{Start: time.Unix(0, 0).Add(start), End: time.Unix(0, 0).Add(end), Items: items}
+}
+
+func identityWindow(window pipeline.Window) interface{} { return window }
+
+func TestTumblingWindow(t *testing.T) {
+	clock := newFakeClock()
+	p := pipeline.Pipeline{pipeline.TumblingWindow(time.Second, identityWindow)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	in <- "a"
+	in <- "b"
+	clock.WaitCreated(t, 2)
+	clock.Advance(time.Second)
+	assert.Equal(t, windowOf(0, time.Second, "a", "b"), <-exec.Out())
+
+	clock.Advance(1500 * time.Millisecond) // the window [1s, 2s) is empty
+	in <- "c"
+	clock.WaitCreated(t, 3)
+	close(in) // the pending window is aggregated
+	assert.Equal(t, windowOf(2*time.Second, 3*time.Second, "c"), <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := newFakeClock()
+	p := pipeline.Pipeline{pipeline.SlidingWindow(2*time.Second, time.Second, identityWindow)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	in <- "a"
+	clock.WaitCreated(t, 1)
+	clock.Advance(time.Second)
+	assert.Equal(t, windowOf(-time.Second, time.Second, "a"), <-exec.Out())
+
+	in <- "b"
+	clock.WaitCreated(t, 3)
+	clock.Advance(time.Second)
+	assert.Equal(t, windowOf(0, 2*time.Second, "a", "b"), <-exec.Out())
+
+	close(in)
+	assert.Equal(t, windowOf(time.Second, 3*time.Second, "b"), <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestSessionWindow(t *testing.T) {
+	clock := newFakeClock()
+	p := pipeline.Pipeline{pipeline.SessionWindow(time.Second, identityWindow)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithClock(clock))
+
+	in <- "a"
+	clock.WaitCreated(t, 1)
+	clock.Advance(500 * time.Millisecond)
+	in <- "b" // extends the session
+	clock.WaitCreated(t, 2)
+	clock.Advance(time.Second)
+	assert.Equal(t, windowOf(0, 1500*time.Millisecond, "a", "b"), <-exec.Out())
+
+	clock.Advance(time.Second)
+	in <- "c"
+	clock.WaitCreated(t, 3)
+	close(in)
+	assert.Equal(t, windowOf(2500*time.Millisecond, 3500*time.Millisecond, "c"), <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestWindow_Aggregate(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.TumblingWindow(time.Hour, func(window pipeline.Window) interface{} {
+			sum := 0
+			for _, item := range window.Items {
+				sum += item.(int)
+			}
+			return sum
+		}),
+	}
+
+	in := make(chan interface{}, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+	close(in)
+
+	out := p.Run(in)
+	assert.Equal(t, 6, <-out)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestWindow_Cancel(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.SessionWindow(time.Hour, identityWindow)}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+	in <- "a"
+	exec.Cancel()
+
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.Equal(t, context.Canceled, exec.Wait())
+}
+
+type event struct {
+	name string
+	time time.Duration
+}
+
+func eventTime(late chan<- interface{}) pipeline.StageOption {
+	return pipeline.EventTime(pipeline.EventTimeConfig{
+		Timestamp:         func(item interface{}) time.Time { return time.Unix(0, 0).Add(item.(event).time) },
+		MaxOutOfOrderness: 5 * time.Second,
+		Late:              late,
+	})
+}
+
+func TestTumblingWindow_EventTime(t *testing.T) {
+	late := make(chan interface{}, 1)
+	p := pipeline.Pipeline{pipeline.TumblingWindow(10*time.Second, identityWindow, eventTime(late))}
+
+	a, b, c, d, e, f := event{"a", 1 * time.Second}, event{"b", 3 * time.Second}, event{"c", 12 * time.Second},
+		event{"d", 8 * time.Second}, event{"e", 16 * time.Second}, event{"f", 2 * time.Second}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+
+	in <- a
+	in <- b
+	in <- c // the watermark (7s) doesn't end the window [0s, 10s)
+	in <- d // out of order, but not late
+	in <- e // the watermark (11s) ends the window [0s, 10s)
+	assert.Equal(t, windowOf(0, 10*time.Second, a, b, d), <-exec.Out())
+
+	in <- f
+	assert.Equal(t, f, <-late)
+
+	close(in)
+	assert.Equal(t, windowOf(10*time.Second, 20*time.Second, c, e), <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
+
+func TestSessionWindow_EventTime(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.SessionWindow(5*time.Second, identityWindow, eventTime(nil))}
+
+	a, b, c, d, e := event{"a", 0}, event{"b", 20 * time.Second}, event{"c", 12 * time.Second},
+		event{"d", 4 * time.Second}, event{"e", 16 * time.Second}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+
+	in <- a
+	in <- b // the watermark (15s) ends the session [0s, 5s)
+	assert.Equal(t, windowOf(0, 5*time.Second, a), <-exec.Out())
+
+	in <- c
+	in <- d // late (dropped)
+	in <- e // merges the sessions [12s, 17s) and [20s, 25s)
+	close(in)
+	assert.Equal(t, windowOf(12*time.Second, 25*time.Second, c, b, e), <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import "context"
+
+// Zip is a producer sending a []interface{} tuple for each position of the given sources (like
+// FromChan or a Pipeline), containing their values at this position, in the sources order. Its
+// output channel is closed as soon as one of the sources is closed: the values of the incomplete
+// tuple are dropped and the other sources are drained. A source which can't be started (see
+// WithPanicPolicy) is closed. The sources are run like Merge.
+func Zip(sources ...Stage) Stage { return ZipWithOptions(nil, sources...) }
+
+// ZipWithOptions is a Zip configured with the given options.
+func ZipWithOptions(opts []StageOption, sources ...Stage) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if len(sources) == 0 || hasNilStage(sources) {
+			return in
+		}
+
+		r := cfg.start(ctx, "zip")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		chs := runSources(r, sources, in)
+		go func() {
+			defer close(out)
+			defer func() {
+				for _, ch := range chs {
+					go flushChan(ch)
+				}
+			}()
+			if hasNilChan(chs) {
+				return
+			}
+
+			for {
+				tuple := make([]interface{}, len(chs))
+				for i, ch := range chs {
+					value, open := recvContext(ctx, ch)
+					if !open {
+						for j := 0; j < i; j++ {
+							r.drop()
+						}
+						return
+					}
+
+					var span *itemSpan
+					tuple[i], span = r.received(value)
+					span.end(nil)
+				}
+
+				if !r.send(out, tuple) {
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
+
+// CombineLatest is a producer sending a []interface{} tuple each time one of the given sources
+// (like FromChan or a Pipeline) sends a value, containing the latest value of each source, in the
+// sources order. The first tuple is sent once all sources have sent a value. A closed source keeps
+// its latest value in the next tuples, and the output channel is closed once all sources are closed;
+// if a source is closed before sending any value, no tuple can be sent and the output channel is
+// closed immediately, the other sources being drained. A source which can't be started (see
+// WithPanicPolicy) is closed. The sources are run like Merge.
+func CombineLatest(sources ...Stage) Stage { return CombineLatestWithOptions(nil, sources...) }
+
+// CombineLatestWithOptions is a CombineLatest configured with the given options.
+func CombineLatestWithOptions(opts []StageOption, sources ...Stage) Stage {
+	cfg := newStageConfig(opts)
+	return builtinStage(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+		if len(sources) == 0 || hasNilStage(sources) {
+			return in
+		}
+
+		r := cfg.start(ctx, "combinelatest")
+		out := make(chan interface{}, cfg.chanSize(ctx, BufferedChanSize))
+		m := &merger{r: r, strategy: MergeRandom, chs: runSources(r, sources, in)}
+		go func() {
+			defer close(out)
+			defer func() {
+				for _, ch := range m.chs {
+					go flushChan(ch)
+				}
+			}()
+			if hasNilChan(m.chs) {
+				return // no tuple can be sent
+			}
+
+			latest := make([]interface{}, len(m.chs))
+			received := make([]bool, len(m.chs))
+			missing := len(m.chs)
+			for {
+				i, value, open, ok := m.recv()
+				switch {
+				case !ok:
+					return
+				case !open && !received[i]:
+					return // no tuple can be sent anymore
+				case !open:
+					continue
+				}
+
+				var span *itemSpan
+				latest[i], span = r.received(value)
+				span.end(nil)
+				if !received[i] {
+					received[i] = true
+					missing--
+				}
+				if missing > 0 {
+					continue
+				}
+
+				if !r.send(out, append([]interface{}(nil), latest...)) {
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
+
+// hasNilChan returns true if one of the given source channels is nil, because the source couldn't
+// be started.
+func hasNilChan(chs []<-chan interface{}) bool {
+	for _, ch := range chs {
+		if ch == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package pipeline_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestZip(t *testing.T) {
+	requests := make(chan interface{}, 3)
+	out := pipeline.Zip(
+		pipeline.FromChan(filledChan("a", "b", "c")),
+		pipeline.Pipeline{
+			pipeline.FromChan(requests),
+			pipeline.C(func(obj interface{}) interface{} { return strings.ToUpper(obj.(string)) }),
+		},
+	).Run(nil)
+
+	requests <- "a"
+	assert.Equal(t, []interface{}{"a", "A"}, <-out)
+	requests <- "b"
+	assert.Equal(t, []interface{}{"b", "B"}, <-out)
+
+	close(requests) // the value "c" can't be paired anymore
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestZip_NoSource(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.Zip().Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
+
+func TestZip_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pending := make(chan interface{})
+	out := pipeline.Zip(pipeline.FromChan(filledChan(1, 2)), pipeline.FromChan(pending)).(pipeline.ContextStage).RunContext(ctx, nil)
+
+	pending <- 1
+	assert.Equal(t, []interface{}{1, 1}, <-out)
+	cancel()
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestZip_PanickingSource(t *testing.T) {
+	panicking := pipeline.StageFnc(func(in <-chan interface{}) <-chan interface{} { panic("unexpected source") })
+	tcases := map[string]pipeline.Stage{
+		"zip":           pipeline.Zip(pipeline.FromChan(filledChan(1, 2)), panicking),
+		"combinelatest": pipeline.CombineLatest(pipeline.FromChan(filledChan(1, 2)), panicking),
+	}
+
+	for name, stage := range tcases {
+		t.Run(name, func(t *testing.T) {
+			exec := pipeline.Pipeline{stage}.Start(context.Background(), filledChan(), pipeline.WithPanicPolicy(pipeline.PanicDrop))
+
+			assertClosed(t, exec.Out(), 100*time.Millisecond)
+		})
+	}
+}
+
+func TestCombineLatest(t *testing.T) {
+	a, b := make(chan interface{}), make(chan interface{})
+	out := pipeline.CombineLatest(pipeline.FromChan(a), pipeline.FromChan(b)).Run(nil)
+
+	a <- 1 // nothing is sent until all sources sent a value
+	b <- "x"
+	assert.Equal(t, []interface{}{1, "x"}, <-out)
+	a <- 2
+	assert.Equal(t, []interface{}{2, "x"}, <-out)
+
+	close(a) // the latest value of a closed source is kept
+	b <- "y"
+	assert.Equal(t, []interface{}{2, "y"}, <-out)
+
+	close(b)
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestCombineLatest_EmptySource(t *testing.T) {
+	pending := make(chan interface{})
+	defer close(pending)
+	out := pipeline.CombineLatest(pipeline.FromChan(pending), pipeline.FromChan(filledChan())).Run(nil)
+
+	assertClosed(t, out, 100*time.Millisecond)
+}
+
+func TestCombineLatest_NoSource(t *testing.T) {
+	in := make(chan interface{})
+	out := pipeline.CombineLatest().Run(in)
+	assert.Equal(t, (<-chan interface{})(in), out)
+}
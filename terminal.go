@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoItem is returned by First and Last when the channel is closed without sending any value.
+var ErrNoItem = errors.New("no item received")
+
+// Collect returns all values of the given channel (like the output channel of a pipeline) once it is
+// closed. If the context is done before, it returns the context error and the channel is drained in
+// background.
+func Collect(ctx context.Context, ch <-chan interface{}) ([]interface{}, error) {
+	var values []interface{}
+	err := drain(ctx, ch, func(value interface{}) bool {
+		values = append(values, value)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Count returns the number of values of the given channel once it is closed, like Collect.
+func Count(ctx context.Context, ch <-chan interface{}) (int, error) {
+	count := 0
+	err := drain(ctx, ch, func(interface{}) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// First returns the first value of the given channel, or ErrNoItem if the channel is closed without
+// value. The next values are drained in background. If the context is done before, it returns the
+// context error.
+func First(ctx context.Context, ch <-chan interface{}) (interface{}, error) {
+	var first interface{}
+	found := false
+	err := drain(ctx, ch, func(value interface{}) bool {
+		first, found = value, true
+		return false
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case !found:
+		return nil, ErrNoItem
+	}
+	return first, nil
+}
+
+// Last returns the last value of the given channel once it is closed, or ErrNoItem if the channel is
+// closed without value, like Collect.
+func Last(ctx context.Context, ch <-chan interface{}) (interface{}, error) {
+	var last interface{}
+	found := false
+	err := drain(ctx, ch, func(value interface{}) bool {
+		last, found = value, true
+		return true
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case !found:
+		return nil, ErrNoItem
+	}
+	return last, nil
+}
+
+// ToMap returns the values of the given channel indexed by the given key function once it is
+// closed, like Collect. A value replaces the previous value with the same key; the keys must be
+// comparable.
+func ToMap(ctx context.Context, ch <-chan interface{}, key func(item interface{}) interface{}) (map[interface{}]interface{}, error) {
+	values := map[interface{}]interface{}{}
+	err := drain(ctx, ch, func(value interface{}) bool {
+		values[key(value)] = value
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// drain calls fnc for each value of the given channel until it is closed or until fnc returns
+// false; the remaining values are then drained in background. It returns the context error if the
+// context is done before.
+func drain(ctx context.Context, ch <-chan interface{}, fnc func(value interface{}) bool) error {
+	for {
+		select {
+		case value, open := <-ch:
+			if !open {
+				return nil
+			}
+			if !fnc(value) {
+				go flushChan(ch)
+				return nil
+			}
+		case <-ctx.Done():
+			go flushChan(ch)
+			return ctx.Err()
+		}
+	}
+}
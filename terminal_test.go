@@ -0,0 +1,78 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestCollect(t *testing.T) {
+	p := pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 })}
+
+	values, err := pipeline.Collect(context.Background(), p.Run(filledChan(1, 2, 3)))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{2, 4, 6}, values)
+}
+
+func TestCollect_Cancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	ch := make(chan interface{})
+	values, err := pipeline.Collect(ctx, ch)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Nil(t, values)
+
+	select {
+	case ch <- 1: // the channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("channel must not be blocked")
+	}
+}
+
+func TestCount(t *testing.T) {
+	count, err := pipeline.Count(context.Background(), filledChan(1, 2, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestFirst(t *testing.T) {
+	ch, sent := make(chan interface{}), make(chan struct{})
+	go func() {
+		defer close(sent)
+		ch <- 1
+		ch <- 2 // the next values are drained
+		close(ch)
+	}()
+
+	first, err := pipeline.First(context.Background(), ch)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, first)
+	select {
+	case <-sent:
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("channel must not be blocked")
+	}
+
+	_, err = pipeline.First(context.Background(), filledChan())
+	assert.Equal(t, pipeline.ErrNoItem, err)
+}
+
+func TestLast(t *testing.T) {
+	last, err := pipeline.Last(context.Background(), filledChan(1, 2, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, last)
+
+	_, err = pipeline.Last(context.Background(), filledChan())
+	assert.Equal(t, pipeline.ErrNoItem, err)
+}
+
+func TestToMap(t *testing.T) {
+	values, err := pipeline.ToMap(context.Background(), filledChan("a", "bb", "cc"), func(item interface{}) interface{} { return len(item.(string)) })
+	assert.NoError(t, err)
+	assert.Equal(t, map[interface{}]interface{}{1: "a", 2: "cc"}, values)
+}
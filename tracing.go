@@ -0,0 +1,221 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span inside a trace.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// Span is the processing of an item by a stage.
+type Span interface {
+	// Context returns the context of the span, which is carried alongside the item to the next stages.
+	Context() SpanContext
+	// End ends the span; err is the error which made the stage drop the item (if any).
+	End(err error)
+}
+
+// Tracer opens the spans of a traced pipeline (see WithTracer). Each built-in stage opens a span for
+// each item it receives, as a child of the span opened by the previous built-in stage. Items
+// received from the pipeline input, from a producer or from a custom stage start a new trace.
+// NOTE: Custom stages only receive the value of the items; the spans opened by the built-in stages
+// they contain (see Pipeline.RunContext) are not linked to the spans of their inputs.
+type Tracer interface {
+	// StartSpan opens a span of the given stage. The parent is empty for items starting a new trace.
+	StartSpan(stage string, parent SpanContext) Span
+}
+
+// tracedItem is an item carrying the context of the last span opened for it.
+type tracedItem struct {
+	value interface{}
+	span  SpanContext
+}
+
+// untrace returns the value of the given item and the context of its last span (if traced).
+func untrace(item interface{}) (interface{}, SpanContext) {
+	if item, isTraced := item.(tracedItem); isTraced {
+		return item.value, item.span
+	}
+	return item, SpanContext{}
+}
+
+func tracerFrom(ctx context.Context) Tracer {
+	if exec := executionFrom(ctx); exec != nil {
+		return exec.tracer
+	}
+	return nil
+}
+
+// untraceChan returns a channel forwarding the values of the items of the given channel, for the
+// stages which don't handle traced items.
+func untraceChan(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+	if inCh == nil || tracerFrom(ctx) == nil {
+		return inCh
+	}
+
+	outCh := make(chan interface{}, cap(inCh))
+	go func() {
+		defer close(outCh)
+		defer flushOnCancel(ctx, inCh)
+
+		for {
+			in, open := recvContext(ctx, inCh)
+			if !open {
+				return
+			}
+
+			value, _ := untrace(in)
+			if !sendContext(ctx, outCh, value) {
+				return
+			}
+		}
+	}()
+	return outCh
+}
+
+// ItemSpan is the span opened by a custom stage for an item (see StageRuntime.Received). A nil
+// ItemSpan (when the pipeline is not traced) does nothing.
+type ItemSpan itemSpan
+
+// End ends the span; err is the error which made the stage drop the item (if any).
+func (s *ItemSpan) End(err error) { (*itemSpan)(s).end(err) }
+
+// itemSpan is the span opened by a stage for an item. A nil itemSpan (when the pipeline is not
+// traced) does nothing.
+type itemSpan struct {
+	span Span
+	err  error
+}
+
+// wrap returns the given value carrying the context of the span.
+func (s *itemSpan) wrap(value interface{}) interface{} {
+	if s == nil {
+		return value
+	}
+	return tracedItem{value: value, span: s.span.Context()}
+}
+
+// fail defines the error ending the span, unless another one is given to end.
+func (s *itemSpan) fail(err error) {
+	if s != nil {
+		s.err = err
+	}
+}
+
+func (s *itemSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	if err == nil {
+		err = s.err
+	}
+	s.span.End(err)
+}
+
+// SpanData describes an ended span.
+type SpanData struct {
+	TraceID  string    `json:"trace_id"`
+	SpanID   string    `json:"span_id"`
+	ParentID string    `json:"parent_id,omitempty"` // ParentID is the ID of the parent span (empty for root spans)
+	Stage    string    `json:"stage"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SpanExporter receives the spans ended by a tracer created with NewTracer.
+type SpanExporter interface {
+	ExportSpan(span SpanData)
+}
+
+// NewTracer returns a Tracer sending the ended spans to the given exporter. Trace and span IDs are
+// random hexadecimal strings.
+func NewTracer(exporter SpanExporter) Tracer { return &tracer{exporter: exporter} }
+
+type tracer struct{ exporter SpanExporter }
+
+func (t *tracer) StartSpan(stage string, parent SpanContext) Span {
+	data := SpanData{TraceID: parent.TraceID, SpanID: newSpanID(8), ParentID: parent.SpanID, Stage: stage, Start: time.Now()}
+	if data.TraceID == "" {
+		data.TraceID = newSpanID(16)
+	}
+	return &span{exporter: t.exporter, data: data}
+}
+
+func newSpanID(size int) string {
+	id := make([]byte, size)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type span struct {
+	exporter SpanExporter
+	data     SpanData
+}
+
+func (s *span) Context() SpanContext {
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID}
+}
+func (s *span) End(err error) {
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	s.exporter.ExportSpan(s.data)
+}
+
+// SpanRecorder is an in-memory SpanExporter, mainly used by tests.
+type SpanRecorder struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+// NewSpanRecorder returns an empty SpanRecorder.
+func NewSpanRecorder() *SpanRecorder { return &SpanRecorder{} }
+
+func (r *SpanRecorder) ExportSpan(span SpanData) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Spans returns all recorded spans, in the order they ended.
+func (r *SpanRecorder) Spans() []SpanData {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
+
+// JSONExporter is a SpanExporter writing each span as a line of JSON.
+type JSONExporter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// NewJSONExporter returns a JSONExporter writing to the given writer.
+func NewJSONExporter(w io.Writer) *JSONExporter { return &JSONExporter{encoder: json.NewEncoder(w)} }
+
+func (e *JSONExporter) ExportSpan(span SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.err == nil {
+		e.err = e.encoder.Encode(span)
+	}
+}
+
+// Err returns the first error raised while writing the spans; the spans ended after are dropped.
+func (e *JSONExporter) Err() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.err
+}
@@ -0,0 +1,160 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+func TestTracer(t *testing.T) {
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.Name("first")),
+		pipeline.LRFilter(
+			func(i interface{}) bool { return i.(int)%2 == 0 },
+			pipeline.Pipeline{pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.Name("even"))},
+			pipeline.Pipeline{pipeline.CE(func(obj interface{}) (interface{}, error) { return nil, errOdd }, pipeline.Name("odd"))},
+		),
+		pipeline.Parallelize(2, pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.Name("worker"))),
+	}
+
+	recorder := pipeline.NewSpanRecorder()
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in,
+		pipeline.WithTracer(pipeline.NewTracer(recorder)),
+		pipeline.WithErrorMode(pipeline.ContinueOnError),
+	)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+
+	go func() {
+		for range exec.Errors() {
+		}
+	}()
+
+	var out []interface{}
+	for value := range exec.Out() {
+		out = append(out, value)
+	}
+	assert.NoError(t, exec.Wait())
+	assert.ElementsMatch(t, []interface{}{0, 2}, out)
+
+	traces := map[string]map[string]pipeline.SpanData{}
+	for _, span := range recorder.Spans() {
+		if traces[span.TraceID] == nil {
+			traces[span.TraceID] = map[string]pipeline.SpanData{}
+		}
+		traces[span.TraceID][span.SpanID] = span
+	}
+	assert.Len(t, traces, 4)
+
+	var paths []string
+	for _, spans := range traces {
+		// walk from the leaf span (which is the parent of no other span) up to the root span
+		children := map[string]bool{}
+		for _, span := range spans {
+			children[span.ParentID] = true
+		}
+
+		for _, span := range spans {
+			if children[span.SpanID] {
+				continue
+			}
+
+			path := []string{}
+			for leaf, exists := span, true; exists; leaf, exists = spans[leaf.ParentID] {
+				path = append([]string{leaf.Stage}, path...)
+				if leaf.ParentID == "" {
+					break
+				}
+			}
+			paths = append(paths, strings.Join(path, " > ")+" ["+span.Error+"]")
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"0.first > 1.lrfilter > 1.lrfilter/left/0.even > 2.parallelize > 2.parallelize/worker []",
+		"0.first > 1.lrfilter > 1.lrfilter/left/0.even > 2.parallelize > 2.parallelize/worker []",
+		"0.first > 1.lrfilter > 1.lrfilter/right/0.odd [odd number]",
+		"0.first > 1.lrfilter > 1.lrfilter/right/0.odd [odd number]",
+	}, paths)
+}
+
+func TestTracer_CustomStage(t *testing.T) {
+	var received []interface{}
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+		pipeline.StageFnc(func(in <-chan interface{}) <-chan interface{} {
+			out := make(chan interface{})
+			go func() {
+				defer close(out)
+				for value := range in {
+					received = append(received, value)
+					out <- value
+				}
+			}()
+			return out
+		}),
+		pipeline.C(func(obj interface{}) interface{} { return obj }),
+	}
+
+	recorder := pipeline.NewSpanRecorder()
+	in := make(chan interface{}, 2)
+	in <- 1
+	in <- 2
+	close(in)
+
+	exec := p.Start(context.Background(), in, pipeline.WithTracer(pipeline.NewTracer(recorder)))
+	assert.Equal(t, 1, <-exec.Out())
+	assert.Equal(t, 2, <-exec.Out())
+	assertClosed(t, exec.Out(), 100*time.Millisecond)
+	assert.NoError(t, exec.Wait())
+
+	assert.Equal(t, []interface{}{1, 2}, received)
+	for _, span := range recorder.Spans() {
+		assert.Empty(t, span.ParentID, "span of %s", span.Stage) // the trace is lost through the custom stage
+	}
+	assert.Len(t, recorder.Spans(), 4)
+}
+
+func TestJSONExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	exporter := pipeline.NewJSONExporter(buf)
+	p := pipeline.Pipeline{
+		pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.Name("a")),
+		pipeline.C(func(obj interface{}) interface{} { return obj }, pipeline.Name("b")),
+	}
+
+	in := make(chan interface{}, 1)
+	in <- 1
+	close(in)
+
+	exec := p.Start(context.Background(), in, pipeline.WithTracer(pipeline.NewTracer(exporter)))
+	assert.Equal(t, 1, <-exec.Out())
+	assert.NoError(t, exec.Wait())
+	assert.NoError(t, exporter.Err())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	var a, b pipeline.SpanData
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &a))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &b))
+	assert.Equal(t, "0.a", a.Stage)
+	assert.Equal(t, "1.b", b.Stage)
+	assert.Equal(t, a.TraceID, b.TraceID)
+	assert.Equal(t, a.SpanID, b.ParentID)
+	assert.Contains(t, lines[0], `"trace_id":"`+a.TraceID+`"`)
+}
@@ -0,0 +1,100 @@
+package typed
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// TypeError is reported when an untyped stage receives or produces a value that doesn't match the
+// type expected by a typed stage.
+type TypeError struct {
+	Value    interface{}
+	Expected reflect.Type
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("unexpected value of type %T (expected %s)", e.Value, e.Expected)
+}
+
+// FromStage converts an untyped stage to a typed one. Values produced by the given stage which are
+// not of type Out are dropped and reported to the pipeline (see pipeline.ReportError) by the
+// converting stage, configured with the given options.
+func FromStage[In, Out any](stage pipeline.Stage, opts ...pipeline.StageOption) Stage[In, Out] {
+	return StageFnc[In, Out](func(ctx context.Context, inCh <-chan In) <-chan Out {
+		if inCh == nil {
+			return nil
+		}
+
+		rt := pipeline.StartStage(ctx, "typed", opts...)
+		untypedIn := make(chan interface{}, rt.ChanSize(cap(inCh)))
+		go func() {
+			defer close(untypedIn)
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, open := recvContext(ctx, inCh)
+				if !open || !sendContext(ctx, untypedIn, interface{}(in)) {
+					return
+				}
+			}
+		}()
+		return assertChan[Out](ctx, rt, pipeline.Pipeline{stage}.RunContext(ctx, untypedIn))
+	})
+}
+
+// ToStage converts a typed stage to an untyped one, usable in a pipeline.Pipeline. Received values
+// which are not of type In are dropped and reported to the pipeline (see pipeline.ReportError) by
+// the converting stage, configured with the given options.
+func ToStage[In, Out any](stage Stage[In, Out], opts ...pipeline.StageOption) pipeline.Stage {
+	return pipeline.StageCtxFnc(func(ctx context.Context, inCh <-chan interface{}) <-chan interface{} {
+		if inCh == nil {
+			return nil
+		}
+
+		rt := pipeline.StartStage(ctx, "typed", opts...)
+		typedOut := stage.Run(ctx, assertChan[In](ctx, rt, inCh))
+		outCh := make(chan interface{}, rt.ChanSize(cap(typedOut)))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, typedOut)
+
+			for {
+				out, open := recvContext(ctx, typedOut)
+				if !open || !sendContext(ctx, outCh, interface{}(out)) {
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
+
+// assertChan converts an untyped channel to a typed one; invalid values are reported by the given
+// stage.
+func assertChan[T any](ctx context.Context, rt *pipeline.StageRuntime, inCh <-chan interface{}) <-chan T {
+	outCh := make(chan T, rt.ChanSize(cap(inCh)))
+	go func() {
+		defer close(outCh)
+		defer flushOnCancel(ctx, inCh)
+
+		for {
+			in, open := recvContext(ctx, inCh)
+			if !open {
+				return
+			}
+
+			out, valid := in.(T)
+			if !valid {
+				pipeline.ReportError(ctx, rt.Name(), in, &TypeError{Value: in, Expected: reflect.TypeOf((*T)(nil)).Elem()})
+				continue
+			}
+			if !sendContext(ctx, outCh, out) {
+				return
+			}
+		}
+	}()
+	return outCh
+}
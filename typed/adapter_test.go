@@ -0,0 +1,66 @@
+package typed_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+	"github.com/xunleii/go-pipeline/typed"
+)
+
+func TestFromStage(t *testing.T) {
+	s := typed.FromStage[int, int](pipeline.C(func(obj interface{}) interface{} { return obj.(int) * 2 }))
+
+	in := make(chan int)
+	out := s.Run(context.Background(), in)
+
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 10, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestToStage(t *testing.T) {
+	p := pipeline.Pipeline{
+		typed.ToStage(typed.Consumer(func(obj int) int { return obj * 2 })),
+		pipeline.C(func(obj interface{}) interface{} { return obj.(int) + 1 }),
+	}
+
+	in := make(chan interface{})
+	out := p.Run(in)
+
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 11, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestToStage_InvalidType(t *testing.T) {
+	p := pipeline.Pipeline{
+		typed.ToStage(typed.Consumer(func(obj int) int { return obj * 2 })),
+	}
+
+	in := make(chan interface{}, 1)
+	exec := p.Start(context.Background(), in)
+
+	in <- "5"
+
+	select {
+	case <-exec.Out():
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("pipeline must be stopped")
+	}
+	err := exec.Wait()
+	if assert.IsType(t, &pipeline.ItemError{}, err) {
+		assert.IsType(t, &typed.TypeError{}, err.(*pipeline.ItemError).Err)
+		assert.Equal(t, "5", err.(*pipeline.ItemError).Item)
+		assert.Equal(t, "0.typed", err.(*pipeline.ItemError).Stage)
+	}
+}
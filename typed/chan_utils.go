@@ -0,0 +1,46 @@
+package typed
+
+import "context"
+
+func flushChan[T any](inCh <-chan T) {
+	if inCh == nil {
+		return
+	}
+	for range inCh {
+	}
+}
+
+// flushOnCancel flushes the given channel in background if the context is done, in order to
+// unblock the stages writing on it.
+func flushOnCancel[T any](ctx context.Context, inCh <-chan T) {
+	if ctx.Err() != nil && inCh != nil {
+		go flushChan(inCh)
+	}
+}
+
+// recvContext receives the next value of the given channel. It returns false if the channel is
+// closed or if the context is done.
+func recvContext[T any](ctx context.Context, inCh <-chan T) (T, bool) {
+	var zero T
+	if ctx.Err() != nil {
+		return zero, false
+	}
+
+	select {
+	case in, open := <-inCh:
+		return in, open
+	case <-ctx.Done():
+		return zero, false
+	}
+}
+
+// sendContext sends the given value to the channel. It returns false if the context is done before
+// the value is sent.
+func sendContext[T any](ctx context.Context, outCh chan<- T, out T) bool {
+	select {
+	case outCh <- out:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,51 @@
+// Package typed provides a type-safe version of the pipeline stages, based on generics. Typed
+// stages can be converted from and to pipeline.Stage in order to be used with existing pipelines.
+// The typed stages report to the instrumentation and the tracer of the pipeline like the built-in
+// stages (see pipeline.StageRuntime), but typed values don't carry their span: the span opened by a
+// typed stage for a value starts a new trace.
+package typed
+
+import (
+	"context"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// Stage is a typed step of a pipeline, consuming values of type In and producing values of type Out.
+// IMPORTANT: The stage must close the output channel when the input channel is closed or when the
+// context is done (to propagate the close signal and stop the pipeline).
+type Stage[In, Out any] interface {
+	Run(ctx context.Context, inCh <-chan In) (outCh <-chan Out)
+}
+
+// StageFnc is a generic function that implements the stage interface.
+type StageFnc[In, Out any] func(ctx context.Context, inCh <-chan In) (outCh <-chan Out)
+
+func (fnc StageFnc[In, Out]) Run(ctx context.Context, inCh <-chan In) (outCh <-chan Out) {
+	return fnc(ctx, inCh)
+}
+
+// Then chains two stages; the output of the first one is the input of the next one. Because the
+// output type of the first stage must be the input type of the next one, a chain of stages only
+// compiles if all adjacent stage types line up.
+func Then[In, Mid, Out any](first Stage[In, Mid], next Stage[Mid, Out]) Stage[In, Out] {
+	return StageFnc[In, Out](func(ctx context.Context, inCh <-chan In) <-chan Out {
+		return next.Run(ctx, first.Run(ctx, inCh))
+	})
+}
+
+// runProtected runs the given stage, but recovers the panics raised when the stage is started (see
+// pipeline.Protect). If the stage can't be started, its input channel is flushed and nil is
+// returned.
+func runProtected[In, Out any](ctx context.Context, name string, stage Stage[In, Out], inCh <-chan In) <-chan Out {
+	for {
+		var outCh <-chan Out
+		ok, policy := pipeline.Protect(ctx, name, nil, func() { outCh = stage.Run(ctx, inCh) })
+		if ok {
+			return outCh
+		} else if policy != pipeline.PanicRestart {
+			go flushChan(inCh)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package typed
+
+import (
+	"context"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// Consumer is the main 'worker'; it consume the given value and return another one. Like the
+// built-in stages, the typed stages are configured with options (see pipeline.Name and
+// pipeline.BufferSize).
+func Consumer[In, Out any](fnc func(obj In) Out, opts ...pipeline.StageOption) Stage[In, Out] {
+	return ConsumerE(func(obj In) (Out, error) { return fnc(obj), nil }, opts...)
+}
+
+// ConsumerE is a Consumer that can fail. When an error is returned, nothing is sent to the next
+// stage and the error is reported to the pipeline with the consumed value (see pipeline.ReportError).
+func ConsumerE[In, Out any](fnc func(obj In) (Out, error), opts ...pipeline.StageOption) Stage[In, Out] {
+	return StageFnc[In, Out](func(ctx context.Context, inCh <-chan In) <-chan Out {
+		if inCh == nil {
+			return nil
+		}
+
+		rt := pipeline.StartStage(ctx, "consumer", opts...)
+		outCh := make(chan Out, rt.ChanSize(pipeline.BufferedChanSize))
+		go func() {
+			defer close(outCh)
+			defer flushOnCancel(ctx, inCh)
+
+			for {
+				in, open := recvContext(ctx, inCh)
+				if !open {
+					return
+				}
+
+				span := rt.Received()
+				var out Out
+				var err error
+				ok, policy := rt.Process(span, in, func() { out, err = fnc(in) })
+				span.End(err)
+				switch {
+				case !ok && policy == pipeline.PanicAbort:
+					go flushChan(inCh)
+					return
+				case !ok:
+					continue
+				case err != nil:
+					rt.ReportError(in, err)
+					continue
+				}
+				if !send(ctx, rt, outCh, out) {
+					return
+				}
+			}
+		}()
+		return outCh
+	})
+}
@@ -0,0 +1,172 @@
+package typed_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+	"github.com/xunleii/go-pipeline/typed"
+)
+
+func TestConsumer(t *testing.T) {
+	c := typed.Consumer(func(obj int) int { return obj * 2 })
+
+	in := make(chan int)
+	out := c.Run(context.Background(), in)
+
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 10, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestConsumer_NilChan(t *testing.T) {
+	c := typed.Consumer(func(obj int) int { return obj * 2 })
+
+	out := c.Run(context.Background(), nil)
+	assert.Nil(t, out)
+}
+
+func TestConsumer_BufferSize(t *testing.T) {
+	c := typed.Consumer(func(obj int) int { return obj * 2 }, pipeline.BufferSize(4))
+
+	in := make(chan int)
+	out := c.Run(context.Background(), in)
+	defer close(in)
+
+	assert.Equal(t, 4, cap(out))
+}
+
+func TestConsumer_WithBufferSize(t *testing.T) {
+	var capacity int
+	p := pipeline.Pipeline{
+		pipeline.StageCtxFnc(func(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+			typedIn := make(chan int)
+			defer close(typedIn)
+
+			capacity = cap(typed.Consumer(func(obj int) int { return obj * 2 }).Run(ctx, typedIn))
+			return in
+		}),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in, pipeline.WithBufferSize(7))
+	close(in)
+
+	assert.NoError(t, exec.Wait())
+	assert.Equal(t, 7, capacity)
+}
+
+func TestConsumer_Reporting(t *testing.T) {
+	p := pipeline.Pipeline{
+		typed.ToStage(typed.Consumer(func(obj int) int { return obj * 2 }, pipeline.Name("double"))),
+	}
+
+	in := make(chan interface{}, 3)
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	close(in)
+
+	collector, recorder := pipeline.NewCollector(), pipeline.NewSpanRecorder()
+	exec := p.Start(context.Background(), in,
+		pipeline.WithInstrumentation(collector),
+		pipeline.WithTracer(pipeline.NewTracer(recorder)),
+	)
+
+	values, err := pipeline.Collect(context.Background(), exec.Out())
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{2, 4, 6}, values)
+
+	metrics := collector.Snapshot()["0.double"]
+	assert.Equal(t, uint64(3), metrics.ItemsIn)
+	assert.Equal(t, uint64(3), metrics.ItemsProcessed)
+	assert.Equal(t, uint64(3), metrics.ItemsOut)
+
+	spans := recorder.Spans()
+	if assert.Len(t, spans, 3) {
+		for _, span := range spans {
+			assert.Equal(t, "0.double", span.Stage)
+		}
+	}
+}
+
+func TestConsumerE(t *testing.T) {
+	c := typed.ConsumerE(func(obj int) (int, error) {
+		if obj < 0 {
+			return 0, errors.New("negative number")
+		}
+		return obj * 2, nil
+	})
+
+	in := make(chan int)
+	out := c.Run(context.Background(), in)
+
+	in <- -1 // dropped
+	in <- 5
+	close(in)
+
+	assert.Equal(t, 10, <-out)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestConsumerE_Name(t *testing.T) {
+	failOn := func(value int) func(obj int) (int, error) {
+		return func(obj int) (int, error) {
+			if obj == value {
+				return 0, errors.New("unexpected value")
+			}
+			return obj, nil
+		}
+	}
+	p := pipeline.Pipeline{
+		typed.ToStage(typed.ConsumerE(failOn(1), pipeline.Name("first"))),
+		typed.ToStage(typed.ConsumerE(failOn(2), pipeline.Name("second"))),
+	}
+
+	in := make(chan interface{}, 3)
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	close(in)
+	exec := p.Start(context.Background(), in, pipeline.WithErrorMode(pipeline.ContinueOnError))
+
+	var stages []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range exec.Errors() {
+			stages = append(stages, err.Stage)
+		}
+	}()
+
+	values, err := pipeline.Collect(context.Background(), exec.Out())
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{3}, values)
+	<-done
+	assert.ElementsMatch(t, []string{"0.first", "1.second"}, stages)
+}
+
+func TestConsumer_Panic(t *testing.T) {
+	p := pipeline.Pipeline{
+		typed.ToStage(typed.Consumer(func(obj int) int { panic("consumer failure") })),
+	}
+
+	in := make(chan interface{})
+	exec := p.Start(context.Background(), in)
+
+	in <- 1
+
+	for range exec.Out() {
+	}
+	err := exec.Wait()
+	if assert.IsType(t, &pipeline.ItemError{}, err) {
+		assert.IsType(t, &pipeline.PanicError{}, err.(*pipeline.ItemError).Err)
+	}
+}
@@ -0,0 +1,75 @@
+package typed
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// LRFilter (or Left/Right Filter) filters values depending on the given predicate. For each value
+// of the input channel, if the predicate returns true, the value is sent to the left stage,
+// otherwise, the value is sent to the right one.
+func LRFilter[In, Out any](predicate func(In) bool, left Stage[In, Out], right Stage[In, Out], opts ...pipeline.StageOption) Stage[In, Out] {
+	return StageFnc[In, Out](func(ctx context.Context, in <-chan In) <-chan Out {
+		if in == nil {
+			return nil
+		}
+
+		rt := pipeline.StartStage(ctx, "lrfilter", opts...)
+		lchan := make(chan In, rt.ChanSize((cap(in)/2)+1))
+		rchan := make(chan In, rt.ChanSize((cap(in)/2)+1))
+		out := make(chan Out, rt.ChanSize(cap(in)))
+
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		go runBranch(rt.Scope("left"), rt, left, wg, lchan, out)
+		go runBranch(rt.Scope("right"), rt, right, wg, rchan, out)
+
+		go func() {
+			defer close(lchan)
+			defer close(rchan)
+			defer flushOnCancel(ctx, in)
+
+			for {
+				value, open := recvContext(ctx, in)
+				if !open {
+					return
+				}
+
+				span := rt.Received()
+				var isLeft bool
+				ok, policy := rt.Process(span, value, func() { isLeft = predicate(value) })
+				span.End(nil)
+				if !ok && policy == pipeline.PanicAbort {
+					go flushChan(in)
+					return
+				} else if !ok {
+					continue
+				}
+
+				ch := rchan
+				if isLeft {
+					ch = lchan
+				}
+				if !dispatch(ctx, rt, ch, value) {
+					return
+				}
+			}
+		}()
+		go func() { wg.Wait(); close(out) }() // Close out only when all goroutine are stopped
+		return out
+	})
+}
+
+// runBranch runs the given branch of a stage with the given context and sends its outputs as outputs
+// of the stage.
+func runBranch[In, Out any](ctx context.Context, rt *pipeline.StageRuntime, stage Stage[In, Out], wg *sync.WaitGroup, in <-chan In, out chan<- Out) {
+	defer wg.Done()
+
+	values := runProtected(ctx, rt.Name(), stage, in)
+	if values == nil {
+		return
+	}
+	forward(ctx, rt, values, out)
+}
@@ -0,0 +1,48 @@
+package typed_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+	"github.com/xunleii/go-pipeline/typed"
+)
+
+func TestLRFilter(t *testing.T) {
+	f := typed.LRFilter(
+		func(i int) bool { return i < 0 },
+		typed.Consumer(func(obj int) string { return "negative" }),
+		typed.Consumer(strconv.Itoa),
+	)
+
+	in := make(chan int)
+	out := f.Run(context.Background(), in)
+
+	in <- 5
+	assert.Equal(t, "5", <-out)
+
+	in <- -5
+	assert.Equal(t, "negative", <-out)
+
+	close(in)
+	_, open := <-out
+	assert.False(t, open)
+}
+
+func TestLRFilter_BufferSize(t *testing.T) {
+	f := typed.LRFilter(
+		func(i int) bool { return i < 0 },
+		typed.Consumer(func(obj int) string { return "negative" }),
+		typed.Consumer(strconv.Itoa),
+		pipeline.BufferSize(4),
+	)
+
+	in := make(chan int)
+	out := f.Run(context.Background(), in)
+	defer close(in)
+
+	assert.Equal(t, 4, cap(out))
+}
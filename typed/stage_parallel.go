@@ -0,0 +1,50 @@
+package typed
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// Parallelize runs n times the given stage and merge theirs outputs in one channel. At least one
+// stage is always run.
+func Parallelize[In, Out any](n int, stage Stage[In, Out], opts ...pipeline.StageOption) Stage[In, Out] {
+	return StageFnc[In, Out](func(ctx context.Context, in <-chan In) <-chan Out {
+		if in == nil {
+			return nil
+		}
+		workers := n
+		if workers < 1 {
+			workers = 1
+		}
+
+		rt := pipeline.StartStage(ctx, "parallelize", opts...)
+		out := make(chan Out, rt.ChanSize(cap(in)*workers)) // We allow each stage to have a full size channel
+		in = observeChan(ctx, rt, in)
+
+		wg := &sync.WaitGroup{}
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go innerStage(rt.Scope(""), rt, stage, wg, in, out)
+		}
+		go func() { wg.Wait(); close(out) }() // Close out only when all goroutine are stopped
+		return out
+	})
+}
+
+func innerStage[In, Out any](ctx context.Context, rt *pipeline.StageRuntime, stage Stage[In, Out], wg *sync.WaitGroup, in <-chan In, out chan<- Out) {
+	defer wg.Done()
+	rt.Worker(1)
+	defer rt.Worker(-1)
+
+	values := runProtected(ctx, rt.Name(), stage, in)
+	if values == nil {
+		return
+	}
+	forward(ctx, rt, values, out)
+
+	// avoid to block in if stage.Run close its output channel unexpectedly but allows to
+	// close global output channel
+	go flushChan(in)
+}
@@ -0,0 +1,91 @@
+package typed_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline"
+	"github.com/xunleii/go-pipeline/typed"
+)
+
+func TestParallelize(t *testing.T) {
+	p := typed.Parallelize(
+		10,
+		typed.Consumer(func(obj int) int { time.Sleep(250 * time.Millisecond); return obj }),
+	)
+
+	in := make(chan int)
+	start := time.Now()
+	out := p.Run(context.Background(), in)
+
+	for i := 0; i < 10; i++ {
+		in <- 1
+	}
+
+	close(in)
+	sum := 0
+	for v := range out {
+		sum += v
+	}
+
+	assert.Equal(t, 10, sum)
+	assert.WithinDuration(t, time.Now(), start.Add(250*time.Millisecond), 100*time.Millisecond)
+}
+
+func TestParallelize_Cancel(t *testing.T) {
+	p := typed.Parallelize(10, typed.Consumer(func(obj int) int { return obj }))
+
+	in := make(chan int)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := p.Run(ctx, in)
+
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+
+	select {
+	case in <- 1: // the input channel must be drained
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("input channel must not be blocked")
+	}
+	for range out {
+	}
+}
+
+func TestParallelize_BufferSize(t *testing.T) {
+	p := typed.Parallelize(2, typed.Consumer(func(obj int) int { return obj }), pipeline.BufferSize(4))
+
+	in := make(chan int)
+	out := p.Run(context.Background(), in)
+	defer close(in)
+
+	assert.Equal(t, 4, cap(out))
+}
+
+func TestParallelize_Reporting(t *testing.T) {
+	p := pipeline.Pipeline{
+		typed.ToStage(typed.Parallelize(2, typed.Consumer(func(obj int) int { return obj }))),
+	}
+
+	in := make(chan interface{}, 4)
+	for i := 0; i < 4; i++ {
+		in <- i
+	}
+	close(in)
+
+	collector := pipeline.NewCollector()
+	exec := p.Start(context.Background(), in, pipeline.WithInstrumentation(collector))
+	values, err := pipeline.Collect(context.Background(), exec.Out())
+	assert.NoError(t, err)
+	assert.Len(t, values, 4)
+
+	assert.Equal(t, []string{"0.parallelize", "0.parallelize/consumer"}, collector.Stages())
+	snapshot := collector.Snapshot()
+	assert.Equal(t, uint64(4), snapshot["0.parallelize"].ItemsIn)
+	assert.Equal(t, uint64(4), snapshot["0.parallelize"].ItemsOut)
+	assert.Equal(t, 0, snapshot["0.parallelize"].ActiveWorkers)
+	assert.Equal(t, uint64(4), snapshot["0.parallelize/consumer"].ItemsProcessed)
+}
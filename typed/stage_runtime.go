@@ -0,0 +1,76 @@
+package typed
+
+import (
+	"context"
+	"time"
+
+	"github.com/xunleii/go-pipeline"
+)
+
+// send sends an output of the stage and reports it to the pipeline (see pipeline.StageRuntime).
+func send[T any](ctx context.Context, rt *pipeline.StageRuntime, outCh chan<- T, out T) bool {
+	if !rt.Reporting() {
+		return sendContext(ctx, outCh, out)
+	}
+
+	start := time.Now()
+	if !sendContext(ctx, outCh, out) {
+		return false
+	}
+	rt.Sent(start, len(outCh))
+	return true
+}
+
+// dispatch sends an input of the stage to one of its branches and reports it to the pipeline.
+func dispatch[T any](ctx context.Context, rt *pipeline.StageRuntime, ch chan<- T, in T) bool {
+	if !rt.Reporting() {
+		return sendContext(ctx, ch, in)
+	}
+
+	start := time.Now()
+	if !sendContext(ctx, ch, in) {
+		return false
+	}
+	rt.Dispatched(start, len(ch))
+	return true
+}
+
+// forward sends all values of the input channel as outputs of the stage, until the input channel is
+// closed or the context is done.
+func forward[T any](ctx context.Context, rt *pipeline.StageRuntime, inCh <-chan T, outCh chan<- T) {
+	defer flushOnCancel(ctx, inCh)
+
+	for {
+		in, open := recvContext(ctx, inCh)
+		if !open || !send(ctx, rt, outCh, in) {
+			return
+		}
+	}
+}
+
+// observeChan returns a channel forwarding all values of the given channel, in order to report the
+// inputs of a stage which doesn't read its input channel itself.
+func observeChan[T any](ctx context.Context, rt *pipeline.StageRuntime, inCh <-chan T) <-chan T {
+	if !rt.Reporting() {
+		return inCh
+	}
+
+	outCh := make(chan T, cap(inCh))
+	go func() {
+		defer close(outCh)
+		defer flushOnCancel(ctx, inCh)
+
+		for {
+			in, open := recvContext(ctx, inCh)
+			if !open {
+				return
+			}
+
+			rt.Received().End(nil)
+			if !sendContext(ctx, outCh, in) {
+				return
+			}
+		}
+	}()
+	return outCh
+}
@@ -0,0 +1,28 @@
+package typed_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xunleii/go-pipeline/typed"
+)
+
+func TestThen(t *testing.T) {
+	s := typed.Then(
+		typed.Consumer(func(obj int) int { return obj * 2 }),
+		typed.Consumer(strconv.Itoa),
+	)
+
+	in := make(chan int)
+	out := s.Run(context.Background(), in)
+
+	in <- 21
+	close(in)
+
+	assert.Equal(t, "42", <-out)
+	_, open := <-out
+	assert.False(t, open)
+}